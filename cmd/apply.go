@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	desiredStateVersion = "k3p.io/v1"
+
+	actionInstall   = "install"
+	actionUpgrade   = "upgrade"
+	actionUnchanged = "unchanged"
+	actionRemove    = "remove"
+)
+
+var (
+	applyFile     string
+	applyPrune    bool
+	applyDryRun   bool
+	applyWait     bool
+	applyTimeout  time.Duration
+	applyForceCRD bool
+	applyLockfile string
+)
+
+// DesiredState is the set of packages that should be installed in a cluster
+type DesiredState struct {
+	APIVersion string           `json:"apiVersion"`
+	Packages   []DesiredPackage `json:"packages"`
+}
+
+// DesiredPackage is a release of a package with the version, profile, answers and values it should be installed with
+type DesiredPackage struct {
+	Name            string                 `json:"name"`
+	Release         string                 `json:"release,omitempty"`
+	Version         string                 `json:"version,omitempty"`
+	Namespace       string                 `json:"namespace,omitempty"`
+	CreateNamespace bool                   `json:"createNamespace,omitempty"`
+	Profile         string                 `json:"profile,omitempty"`
+	Answers         map[string]string      `json:"answers,omitempty"`
+	Values          map[string]interface{} `json:"values,omitempty"`
+}
+
+// planStep is a change apply makes to a release
+type planStep struct {
+	Action  string         `json:"action"`
+	Release Release        `json:"release"`
+	From    string         `json:"from,omitempty"`
+	Reasons []string       `json:"changes,omitempty"`
+	Options installOptions `json:"-"`
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Install, upgrade and optionally remove releases to match a desired state file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if applyFile == "" {
+			return usageError("a desired state file is required, use -f")
+		}
+
+		state, err := loadDesiredState(applyFile)
+		if err != nil {
+			return err
+		}
+
+		lockfile := applyLockfile
+		if lockfile == "" {
+			lockfile = filepath.Join(filepath.Dir(applyFile), defaultLockfile)
+		}
+		lock, err := loadLockfile(lockfile, applyLockfile != "")
+		if err != nil {
+			return err
+		}
+
+		plan, err := planDesiredState(state, applyPrune, lock)
+		if err != nil {
+			return err
+		}
+		cmdResult.Data = plan
+		cmdResult.Action = "plan"
+		if applyDryRun {
+			return printResult(func(w io.Writer) {
+				printPlan(w, plan)
+			})
+		}
+
+		// show the plan before running it so a failing step still leaves it behind. The JSON or YAML result is
+		// only printed once the plan ran, until then the plan goes to stderr with the progress
+		cmdResult.Action = "apply"
+		if !structuredOutput() {
+			if err := printResult(func(w io.Writer) {
+				printPlan(w, plan)
+			}); err != nil {
+				return err
+			}
+			return executePlan(plan)
+		}
+		printPlan(os.Stderr, plan)
+		if err := executePlan(plan); err != nil {
+			return err
+		}
+		return printResult(nil)
+	},
+}
+
+func init() {
+	applyCmd.Flags().StringVarP(&applyFile, "filename", "f", "", "desired state file listing the packages to install")
+	applyCmd.Flags().BoolVarP(&applyPrune, "prune", "", false, "delete releases installed by k3p that are not in the file")
+	applyCmd.Flags().BoolVarP(&applyDryRun, "dry-run", "", false, "only show the plan")
+	applyCmd.Flags().BoolVarP(&applyWait, "wait", "", false, "wait until the workloads and CRDs of each package are ready")
+	applyCmd.Flags().DurationVarP(&applyTimeout, "timeout", "", 5*time.Minute, "how long to wait with --wait, also bounds waiting for CRDs to be established")
+	applyCmd.Flags().StringVarP(&applyLockfile, "lockfile", "", "", "lockfile the packages must match, defaults to k3p.lock next to the desired state file if it exists")
+	applyCmd.Flags().BoolVarP(&applyForceCRD, "force-crd", "", false, "apply CRD changes even if they remove stored versions or narrow the schema")
+}
+
+func loadDesiredState(file string) (*DesiredState, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	state := &DesiredState{}
+	if err := yaml.Unmarshal(data, state); err != nil {
+		return nil, withExitCode(exitValidation, errors.Wrapf(err, "failed to parse %s", file))
+	}
+	if state.APIVersion != desiredStateVersion {
+		return nil, validationError("unsupported apiVersion %q in %s, expected %q", state.APIVersion, file, desiredStateVersion)
+	}
+
+	releases := map[string]bool{}
+	for i, p := range state.Packages {
+		if p.Name == "" {
+			return nil, validationError("package %d in %s has no name", i+1, file)
+		}
+		key := p.Namespace + "/" + p.releaseName()
+		if releases[key] {
+			return nil, validationError("release %s is listed more than once in %s", p.releaseName(), file)
+		}
+		releases[key] = true
+	}
+	return state, nil
+}
+
+func (p DesiredPackage) releaseName() string {
+	if p.Release != "" {
+		return p.Release
+	}
+	return p.Name
+}
+
+// answers returns the answers as sorted key=value pairs
+func (p DesiredPackage) answers() []string {
+	var result []string
+	for _, k := range sortedKeys(p.Answers) {
+		result = append(result, fmt.Sprintf("%s=%s", k, p.Answers[k]))
+	}
+	return result
+}
+
+func (p DesiredPackage) values() ([]string, error) {
+	if len(p.Values) == 0 {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(p.Values)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(data)}, nil
+}
+
+// planDesiredState compares the desired state with the installed releases. Installs and upgrades are ordered so
+// dependencies come first, removals so dependents come first.
+func planDesiredState(state *DesiredState, prune bool, lock *Lockfile) ([]planStep, error) {
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	releases, err := listReleases()
+	if err != nil {
+		return nil, err
+	}
+	installed := map[string]Release{}
+	for _, r := range releases {
+		installed[releaseKey(r)] = r
+	}
+
+	var steps []planStep
+	desiredPackages := map[string]bool{}
+	desiredReleases := map[string]bool{}
+	for _, p := range state.Packages {
+		packageYaml, err := loadPackageYaml(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		available, ok := index.Find(p.Name)
+		if !ok {
+			return nil, notFoundError("package %s is not in the local index, run k3p update", p.Name)
+		}
+		if ok, err := versionSatisfies(available.Version, p.Version); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, validationError("package %s %s in the local cache doesn't satisfy %q, run k3p update", p.Name, available.Version, p.Version)
+		}
+
+		if lock != nil {
+			if err := lock.Verify(p.Name); err != nil {
+				return nil, err
+			}
+		}
+
+		values, err := p.values()
+		if err != nil {
+			return nil, err
+		}
+		namespace, err := releaseNamespace(p.Namespace, packageYaml)
+		if err != nil {
+			return nil, err
+		}
+		desired := Release{
+			Name:      p.releaseName(),
+			Namespace: namespace,
+			Package:   p.Name,
+			Version:   available.Version,
+			Profile:   p.Profile,
+			Answers:   p.answers(),
+			Values:    values,
+		}
+		desiredPackages[p.Name] = true
+		desiredReleases[releaseKey(desired)] = true
+
+		step := planStep{
+			Action:  actionInstall,
+			Release: desired,
+			Options: installOptions{
+				ReleaseName:     desired.Name,
+				Namespace:       desired.Namespace,
+				CreateNamespace: p.CreateNamespace,
+				Profile:         desired.Profile,
+				Answers:         desired.Answers,
+				Values:          desired.Values,
+				Wait:            applyWait,
+				Timeout:         applyTimeout,
+				ForceCRD:        applyForceCRD,
+				Lock:            lock,
+			},
+		}
+		if current, ok := installed[releaseKey(desired)]; ok {
+			step.From = current.Version
+			step.Options.PrivateRegistry = current.PrivateRegistry
+			step.Options.RegistryMirrors = current.RegistryMirrors
+			step.Options.ImagePullSecrets = current.ImagePullSecrets
+			step.Options.CustomOptions = current.CustomOptions
+			step.Reasons = releaseChanges(current, desired)
+			step.Action = actionUpgrade
+			if len(step.Reasons) == 0 {
+				step.Action = actionUnchanged
+			}
+		}
+		steps = append(steps, step)
+	}
+
+	for _, step := range steps {
+		packageYaml, err := loadPackageYaml(step.Release.Package)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range packageYaml.DependsOn {
+			if desiredPackages[dep.Name] {
+				continue
+			}
+			if _, ok := installedPackage(releases, dep.Name); !ok {
+				return nil, validationError("package %s depends on %s, which is neither installed nor listed", step.Release.Package, dep.Name)
+			}
+			if prune {
+				return nil, validationError("package %s depends on %s, which is not listed and would be pruned", step.Release.Package, dep.Name)
+			}
+		}
+	}
+	steps = orderSteps(steps)
+
+	if prune {
+		var removals []Release
+		for _, r := range releases {
+			if !desiredReleases[releaseKey(r)] {
+				removals = append(removals, r)
+			}
+		}
+		ordered := upgradeOrder(removals)
+		for i := len(ordered) - 1; i >= 0; i-- {
+			steps = append(steps, planStep{
+				Action:  actionRemove,
+				Release: ordered[i],
+				From:    ordered[i].Version,
+			})
+		}
+	}
+	return steps, nil
+}
+
+func installedPackage(releases []Release, packageName string) (Release, bool) {
+	for _, r := range releases {
+		if r.Package == packageName {
+			return r, true
+		}
+	}
+	return Release{}, false
+}
+
+// releaseChanges describes how a desired release differs from the installed one
+func releaseChanges(current, desired Release) []string {
+	var result []string
+	if current.Package != desired.Package {
+		result = append(result, fmt.Sprintf("package %s -> %s", current.Package, desired.Package))
+	}
+	if current.Version != desired.Version {
+		result = append(result, fmt.Sprintf("version %s -> %s", current.Version, desired.Version))
+	}
+	if current.Profile != desired.Profile {
+		result = append(result, fmt.Sprintf("profile %q -> %q", current.Profile, desired.Profile))
+	}
+	if !equalStrings(current.Answers, desired.Answers) {
+		result = append(result, "answers")
+	}
+	if !equalStrings(current.Values, desired.Values) {
+		result = append(result, "values")
+	}
+	return result
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// orderSteps sorts the steps so the releases of a package come after the releases of the packages it depends on
+func orderSteps(steps []planStep) []planStep {
+	var releases []Release
+	byKey := map[string]planStep{}
+	for _, step := range steps {
+		releases = append(releases, step.Release)
+		byKey[releaseKey(step.Release)] = step
+	}
+
+	var result []planStep
+	for _, r := range upgradeOrder(releases) {
+		result = append(result, byKey[releaseKey(r)])
+	}
+	return result
+}
+
+func printPlan(out io.Writer, plan []planStep) {
+	counts := map[string]int{}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tRELEASE\tNAMESPACE\tPACKAGE\tFROM\tTO\tCHANGES")
+	for _, step := range plan {
+		counts[step.Action]++
+		to := step.Release.Version
+		if step.Action == actionRemove {
+			to = ""
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", step.Action, step.Release.Name, step.Release.Namespace,
+			step.Release.Package, step.From, to, strings.Join(step.Reasons, ", "))
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "Plan: %d to install, %d to upgrade, %d to remove, %d unchanged\n",
+		counts[actionInstall], counts[actionUpgrade], counts[actionRemove], counts[actionUnchanged])
+}
+
+// executePlan runs the steps of a plan in order and stops at the first failure
+func executePlan(plan []planStep) error {
+	for _, step := range plan {
+		switch step.Action {
+		case actionInstall, actionUpgrade:
+			logrus.Infof("Applying %s of release %s", step.Action, step.Release.Name)
+			if err := installPackage(step.Release.Package, step.Options); err != nil {
+				return errors.Wrapf(err, "%s of release %s failed", step.Action, step.Release.Name)
+			}
+		case actionRemove:
+			logrus.Infof("Removing release %s", step.Release.Name)
+			if err := deletePackage(step.Release); err != nil {
+				return errors.Wrapf(err, "removing release %s failed", step.Release.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// withFakeCluster runs a test against a cache in a temp dir and a kubectl script that answers the given commands
+func withFakeCluster(t *testing.T, kubectl string, test func(cache string)) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake kubectl is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "k3p-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "kubectl"), []byte("#!/bin/sh\n"+kubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	defer func(old string) { cacheDirFlag = old }(cacheDirFlag)
+	cacheDirFlag = filepath.Join(dir, "cache")
+	test(cacheDirFlag)
+}
+
+func writeTestFile(t *testing.T, file, content string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a release of foo recorded without a namespace in a ConfigMap in the default namespace
+const fooReleaseKubectl = `case "$*" in
+  "config view"*) ;;
+  "get configmap --all-namespaces"*) cat <<'EOF'
+{"items":[{"metadata":{"name":"k3p-release-foo","namespace":"default"},"data":{"release.yaml":"name: foo\npackage: foo\nversion: 1.0.0\n"}}]}
+EOF
+  ;;
+  "get secret --all-namespaces"*) echo '{"items":[]}';;
+  *) exit 1;;
+esac
+`
+
+func TestPlanDesiredStateWithoutNamespace(t *testing.T) {
+	withFakeCluster(t, fooReleaseKubectl, func(cache string) {
+		writeTestFile(t, filepath.Join(cache, "index.yaml"), "packages:\n- name: foo\n  version: 1.0.0\n")
+		writeTestFile(t, filepath.Join(cache, "foo", "package.yaml"), "{}\n")
+
+		steps, err := planDesiredState(&DesiredState{Packages: []DesiredPackage{{Name: "foo"}}}, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(steps) != 1 {
+			t.Fatalf("expected a single step, got %+v", steps)
+		}
+		if steps[0].Action != actionUnchanged {
+			t.Errorf("expected foo to be %s, got %s", actionUnchanged, steps[0].Action)
+		}
+		if steps[0].Release.Namespace != "default" {
+			t.Errorf("expected the release in the current namespace default, got %q", steps[0].Release.Namespace)
+		}
+	})
+}
+
+func TestApplyPrintsPlanBeforeFailingStep(t *testing.T) {
+	withFakeCluster(t, fooReleaseKubectl, func(cache string) {
+		writeTestFile(t, filepath.Join(cache, "index.yaml"), "packages:\n- name: bar\n  version: 1.0.0\n")
+		writeTestFile(t, filepath.Join(cache, "bar", "package.yaml"), "{}\n")
+		state := filepath.Join(filepath.Dir(cache), "state.yaml")
+		writeTestFile(t, state, "apiVersion: "+desiredStateVersion+"\npackages:\n- name: bar\n")
+
+		stdout := filepath.Join(filepath.Dir(cache), "stdout")
+		out, err := os.Create(stdout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+		defer func(old *os.File) { os.Stdout = old }(os.Stdout)
+		os.Stdout = out
+		defer func(old string) { applyFile = old }(applyFile)
+		applyFile = state
+		defer func(old *Result) { cmdResult = old }(cmdResult)
+		cmdResult = &Result{}
+
+		if err := applyCmd.RunE(applyCmd, nil); err == nil {
+			t.Fatal("expected the install of bar to fail")
+		}
+		printed, err := ioutil.ReadFile(stdout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(printed), "Plan: 1 to install") {
+			t.Errorf("expected the plan to be printed before the failing step, got %q", printed)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	backupFormatVersion = "k3p.io/backup/v1"
+	backupManifestFile  = "backup.yaml"
+	backupValuesFile    = "values.yaml"
+)
+
+var (
+	backupFile      string
+	backupNamespace string
+)
+
+// BackupManifest is the first entry of a backup and describes its content
+type BackupManifest struct {
+	Version   string   `json:"version"`
+	Created   string   `json:"created,omitempty"`
+	Package   string   `json:"package"`
+	Release   *Release `json:"release,omitempty"`
+	CRDs      []string `json:"crds,omitempty"`
+	Resources int      `json:"resources"`
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the helm values, CRDs and custom resources of a package or release",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, files, err := backupPackage(args[0], backupNamespace)
+		if err != nil {
+			return err
+		}
+
+		output := backupFile
+		if output == "" {
+			output = fmt.Sprintf("%s-backup-%s.tar.gz", args[0], time.Now().UTC().Format("20060102-150405"))
+		}
+		manifestData, err := yaml.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		files[backupManifestFile] = manifestData
+		if err := writeTarball(output, files, backupManifestFile); err != nil {
+			return err
+		}
+		cmdResult.Action = "backup"
+		cmdResult.Data = manifest
+		touched("written", "File", "", output)
+		return printResult(func(w io.Writer) {
+			fmt.Fprintf(w, "Backed up %d CRDs and %d custom resources of package %s to %s\n",
+				len(manifest.CRDs), manifest.Resources, manifest.Package, output)
+		})
+	},
+}
+
+func init() {
+	backupCmd.Flags().StringVarP(&backupFile, "file", "f", "", "file to write the backup to, defaults to <package>-backup-<time>.tar.gz")
+	backupCmd.Flags().StringVarP(&backupNamespace, "namespace", "n", "", "namespace of the release")
+}
+
+// backupPackage collects the files of a backup. name is a release installed by k3p or a package name.
+func backupPackage(name, namespace string) (*BackupManifest, map[string][]byte, error) {
+	manifest := &BackupManifest{
+		Version: backupFormatVersion,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Package: name,
+	}
+	files := map[string][]byte{}
+
+	release, err := findRelease(name, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if release != nil {
+		manifest.Package = release.Package
+		manifest.Release = release
+		values, err := helm(namespaceArgs(release.Namespace, "get", "values", release.Name, "-o", "yaml")...)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[backupValuesFile] = values
+	}
+
+	packageYaml, err := loadPackageYaml(manifest.Package)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kinds, err := crdKinds(packageYaml.CRDManifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, kind := range kinds {
+		output, err := kubectl(nil, "get", "customresourcedefinition", kind.Name, "--ignore-not-found", "-o", "json")
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(output) == 0 {
+			continue
+		}
+		crd := map[string]interface{}{}
+		if err := json.Unmarshal(output, &crd); err != nil {
+			return nil, nil, err
+		}
+		data, err := objectsYaml([]map[string]interface{}{crd})
+		if err != nil {
+			return nil, nil, err
+		}
+		files[path.Join("crds", kind.Name+".yaml")] = data
+		manifest.CRDs = append(manifest.CRDs, kind.Name)
+	}
+
+	resources, err := listCustomResources(packageYaml.CRDManifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range resources {
+		for _, item := range r.Items {
+			data, err := objectsYaml([]map[string]interface{}{item})
+			if err != nil {
+				return nil, nil, err
+			}
+			files[backupResourcePath(r.CRD, item)] = data
+			manifest.Resources++
+		}
+	}
+
+	return manifest, files, nil
+}
+
+// backupResourcePath returns resources/<kind>/<namespace>/<name>.yaml, cluster scoped resources use _cluster as namespace
+func backupResourcePath(kind crdKind, obj map[string]interface{}) string {
+	ns := nestedString(obj, "metadata", "namespace")
+	if ns == "" {
+		ns = "_cluster"
+	}
+	return path.Join("resources", strings.ToLower(kind.Resource()), ns, nestedString(obj, "metadata", "name")+".yaml")
+}
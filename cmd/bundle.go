@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	bundleFormatVersion = "k3p.io/bundle/v1"
+	bundleManifestFile  = "bundle.yaml"
+)
+
+var (
+	bundleFile    string
+	bundleProfile string
+	bundleVerify  bool
+)
+
+// BundleManifest is the first entry of a bundle and describes its content
+type BundleManifest struct {
+	Version   string            `json:"version"`
+	Created   string            `json:"created,omitempty"`
+	Packages  []IndexPackage    `json:"packages,omitempty"`
+	Checksums map[string]string `json:"checksums,omitempty"`
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Bundle packages into a self-contained archive for air-gapped installs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if bundleVerify {
+			if len(args) != 1 {
+				return usageError("exact one bundle file is required")
+			}
+			manifest, _, err := readBundle(args[0])
+			if err != nil {
+				return err
+			}
+			cmdResult.Action = "verify"
+			cmdResult.Data = manifest
+			return printResult(func(w io.Writer) {
+				fmt.Fprintf(w, "Bundle %s (%s) is valid\n", args[0], manifest.Version)
+				for _, p := range manifest.Packages {
+					fmt.Fprintf(w, "  %s %s\n", p.Name, p.Version)
+				}
+			})
+		}
+
+		if len(args) == 0 {
+			return usageError("at least one package is required")
+		}
+
+		index, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		files := map[string][]byte{}
+		manifest := BundleManifest{
+			Version:   bundleFormatVersion,
+			Created:   time.Now().UTC().Format(time.RFC3339),
+			Checksums: map[string]string{},
+		}
+		for _, packageName := range args {
+			logrus.Infof("Bundling package %s", packageName)
+			p, ok := index.Find(packageName)
+			if !ok {
+				p = IndexPackage{Name: packageName}
+			}
+			manifest.Packages = append(manifest.Packages, p)
+
+			if err := addPackageToBundle(files, packageName); err != nil {
+				return err
+			}
+		}
+
+		indexData, err := yaml.Marshal(&Index{Packages: manifest.Packages})
+		if err != nil {
+			return err
+		}
+		files["index.yaml"] = indexData
+
+		for name, data := range files {
+			manifest.Checksums[name] = sha256Hex(data)
+		}
+
+		if err := writeBundle(bundleFile, manifest, files); err != nil {
+			return err
+		}
+		cmdResult.Action = "bundle"
+		cmdResult.Data = manifest
+		touched("written", "File", "", bundleFile)
+		return printResult(func(w io.Writer) {
+			fmt.Fprintf(w, "Bundle written to %s\n", bundleFile)
+		})
+	},
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleFile, "file", "f", "k3p-bundle.tar.gz", "bundle file to write")
+	bundleCmd.Flags().StringVarP(&bundleProfile, "profile", "p", "", "profile used to compute the image list")
+	bundleCmd.Flags().BoolVarP(&bundleVerify, "verify", "", false, "verify the checksums of an existing bundle instead of creating one")
+}
+
+func addPackageToBundle(files map[string][]byte, packageName string) error {
+	packageYaml, err := loadPackageYaml(packageName)
+	if err != nil {
+		return err
+	}
+	prefix := path.Join("packages", packageName)
+
+	packageYamlData, err := ioutil.ReadFile(filepath.Join(packageDir(packageName), "package.yaml"))
+	if err != nil {
+		return err
+	}
+	files[path.Join(prefix, "package.yaml")] = packageYamlData
+
+	if packageYaml.CRDManifest != "" {
+		files[path.Join(prefix, "crds.yaml")] = []byte(packageYaml.CRDManifest)
+	}
+
+	images, err := packageImages(packageName, packageYaml, bundleProfile)
+	if err != nil {
+		return err
+	}
+	var imageList bytes.Buffer
+	for _, image := range images {
+		imageList.WriteString(image.Image + "\n")
+	}
+	files[path.Join(prefix, "images.txt")] = imageList.Bytes()
+
+	// keep the downloaded chart and patches so a lockfile can be verified against the imported package
+	kept := []string{baseArchive}
+	for _, patch := range packageYaml.Patches {
+		kept = append(kept, patch.Name)
+	}
+	for _, name := range kept {
+		data, err := ioutil.ReadFile(filepath.Join(packageDir(packageName), name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		files[path.Join(prefix, name)] = data
+	}
+
+	base := chartDir(packageName)
+	return filepath.Walk(base, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		files[path.Join(prefix, "chart", filepath.ToSlash(rel))] = data
+		return nil
+	})
+}
+
+func writeBundle(file string, manifest BundleManifest, files map[string][]byte) error {
+	manifestData, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	entries := map[string][]byte{bundleManifestFile: manifestData}
+	for name, data := range files {
+		entries[name] = data
+	}
+	return writeTarball(file, entries, bundleManifestFile)
+}
+
+// writeTarball writes files to a gzipped tarball, the files named in first come before the others
+func writeTarball(file string, files map[string][]byte, first ...string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+
+	names := []string{}
+	for name := range files {
+		if !contains(first, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range append(first, names...) {
+		if err := writeTarFile(tw, name, files[name]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readBundle reads a bundle and verifies its format version and the checksum of every file
+func readBundle(file string) (*BundleManifest, map[string][]byte, error) {
+	files, err := readTarball(file)
+	if err != nil {
+		return nil, nil, withExitCode(exitValidation, errors.Wrapf(err, "%s is not a k3p bundle", file))
+	}
+
+	manifestData, ok := files[bundleManifestFile]
+	if !ok {
+		return nil, nil, validationError("%s is not a k3p bundle: missing %s", file, bundleManifestFile)
+	}
+	delete(files, bundleManifestFile)
+
+	manifest := &BundleManifest{}
+	if err := yaml.Unmarshal(manifestData, manifest); err != nil {
+		return nil, nil, err
+	}
+	if manifest.Version != bundleFormatVersion {
+		return nil, nil, validationError("unsupported bundle version %q, expected %q", manifest.Version, bundleFormatVersion)
+	}
+
+	for _, p := range manifest.Packages {
+		if err := validatePackageName(p.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for name, data := range files {
+		checksum, ok := manifest.Checksums[name]
+		if !ok {
+			return nil, nil, validationError("bundle file %s has no checksum", name)
+		}
+		if actual := sha256Hex(data); actual != checksum {
+			return nil, nil, validationError("checksum mismatch for bundle file %s: expected %s, got %s", name, checksum, actual)
+		}
+	}
+	for name := range manifest.Checksums {
+		if _, ok := files[name]; !ok {
+			return nil, nil, validationError("bundle file %s is missing", name)
+		}
+	}
+
+	return manifest, files, nil
+}
+
+// readTarball reads the regular files of a gzipped tarball, refusing paths outside of the archive
+func readTarball(file string) (map[string][]byte, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	gzr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, validationError("invalid path %s", header.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// importBundle verifies a bundle and replaces the cached data of its packages with the bundled ones. Commands
+// importing a bundle hold the exclusive cache lock, see lockCacheFor
+func importBundle(file string) (*BundleManifest, error) {
+	manifest, files, err := readBundle(file)
+	if err != nil {
+		return nil, err
+	}
+	bundleIndex := &Index{}
+	if err := yaml.Unmarshal(files["index.yaml"], bundleIndex); err != nil {
+		return nil, err
+	}
+	for _, p := range bundleIndex.Packages {
+		if err := validatePackageName(p.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range manifest.Packages {
+		dir := packageDir(p.Name)
+		logrus.Infof("Removing old data from directory %v", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+
+		prefix := path.Join("packages", p.Name) + "/"
+		for name, data := range files {
+			if !strings.HasPrefix(name, prefix) || name == prefix+"images.txt" || name == prefix+"crds.yaml" {
+				continue
+			}
+			target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(target, data, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	index.Merge(bundleIndex)
+	if err := saveIndex(index); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cacheDirEnv = "K3P_CACHE_DIR"
+
+var (
+	cacheDirFlag string
+
+	cachePruneDryRun bool
+	cacheLockfile    string
+)
+
+// cachedPackage is a package in the local cache
+type cachedPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Indexed bool   `json:"indexed"`
+	Size    int64  `json:"size"`
+}
+
+// cacheProblem lists what is wrong with a cached package
+type cacheProblem struct {
+	Package  string   `json:"package"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and clean the local package cache",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the packages in the local cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		packages, err := cachedPackages()
+		if err != nil {
+			return err
+		}
+
+		cmdResult.Data = packages
+		return printResult(func(out io.Writer) {
+			w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "PACKAGE\tVERSION\tINDEXED\tSIZE")
+			for _, p := range packages {
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", p.Name, p.Version, p.Indexed, formatSize(p.Size))
+			}
+			w.Flush()
+		})
+	},
+}
+
+var cacheSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "Show the disk space used by the local cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		size, err := dirSize(cacheDir())
+		if err != nil {
+			return err
+		}
+
+		cmdResult.Data = map[string]interface{}{
+			"path": cacheDir(),
+			"size": size,
+		}
+		return printResult(func(out io.Writer) {
+			fmt.Fprintf(out, "%s\t%s\n", formatSize(size), cacheDir())
+		})
+	},
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean [package...]",
+	Short: "Remove packages from the local cache, or the whole cache without arguments",
+	Annotations: map[string]string{
+		cacheLockAnnotation: cacheLockExclusive,
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmdResult.Action = "clean"
+		if len(args) == 0 {
+			logrus.Infof("Removing the content of cache directory %v", cacheDir())
+			if err := clearCacheDir(); err != nil {
+				return err
+			}
+			touched("deleted", "Directory", "", cacheDir())
+			return printResult(nil)
+		}
+
+		index, err := loadIndex()
+		if err != nil {
+			return err
+		}
+		for _, name := range args {
+			if err := validatePackageName(name); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if _, err := os.Stat(packageDir(name)); os.IsNotExist(err) {
+				return notFoundError("package %s is not in the local cache", name)
+			}
+		}
+		for _, name := range args {
+			if err := removeCachedPackage(index, name); err != nil {
+				return err
+			}
+		}
+		if err := saveIndex(index); err != nil {
+			return err
+		}
+		return printResult(nil)
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached packages that are no longer in the package index",
+	Annotations: map[string]string{
+		cacheLockAnnotation: cacheLockExclusive,
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmdResult.Action = "prune"
+		if cachePruneDryRun {
+			cmdResult.Action = "none"
+		}
+		packages, err := cachedPackages()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range packages {
+			if p.Indexed {
+				// older versions of k3p left an empty <package>/<package> directory behind
+				stray := filepath.Join(packageDir(p.Name), p.Name)
+				if empty, _ := isEmptyDir(stray); empty && !cachePruneDryRun {
+					if err := os.Remove(stray); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if cachePruneDryRun {
+				logrus.Infof("Would remove package %s", p.Name)
+				continue
+			}
+			logrus.Infof("Removing package %s", p.Name)
+			if err := os.RemoveAll(packageDir(p.Name)); err != nil {
+				return err
+			}
+			touched("deleted", "Package", "", p.Name)
+		}
+		return printResult(nil)
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify [package...]",
+	Short: "Check that cached packages are complete and match the lockfile",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := loadLockfile(cacheLockfile, cmd.Flags().Changed("lockfile"))
+		if err != nil {
+			return err
+		}
+
+		names := args
+		if len(names) == 0 {
+			packages, err := cachedPackages()
+			if err != nil {
+				return err
+			}
+			for _, p := range packages {
+				names = append(names, p.Name)
+			}
+		}
+
+		results := []cacheProblem{}
+		failed := 0
+		for _, name := range names {
+			problems, err := verifyCachedPackage(name, lock)
+			if err != nil {
+				return err
+			}
+			if len(problems) > 0 {
+				failed++
+			}
+			results = append(results, cacheProblem{Package: name, Problems: problems})
+		}
+
+		cmdResult.Data = results
+		if err := printResult(func(out io.Writer) {
+			w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "PACKAGE\tSTATUS")
+			for _, r := range results {
+				status := "ok"
+				if len(r.Problems) > 0 {
+					status = strings.Join(r.Problems, "; ")
+				}
+				fmt.Fprintf(w, "%s\t%s\n", r.Package, status)
+			}
+			w.Flush()
+		}); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return validationError("%d of %d cached packages failed verification", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&cacheDirFlag, "cache-dir", "", "", "directory of the local package cache, defaults to $"+cacheDirEnv+" or $XDG_CACHE_HOME/k3p")
+
+	cachePruneCmd.Flags().BoolVarP(&cachePruneDryRun, "dry-run", "", false, "only show which packages would be removed")
+	cacheVerifyCmd.Flags().StringVarP(&cacheLockfile, "lockfile", "", defaultLockfile, "also compare the packages to this lockfile, if it exists")
+
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheSizeCmd)
+	cacheCmd.AddCommand(cacheCleanCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
+}
+
+// cacheDir returns the root of the local package cache: --cache-dir, $K3P_CACHE_DIR, the user cache directory or, if
+// HOME isn't set either, a directory in the temp dir. A cache of older k3p versions in $HOME/.k3s-chart-data is only
+// used if migrateLegacyCache couldn't move it.
+func cacheDir() string {
+	if cacheDirFlag != "" {
+		return cacheDirFlag
+	}
+	if dir := os.Getenv(cacheDirEnv); dir != "" {
+		return dir
+	}
+	dir := defaultCacheDir()
+	if legacy := legacyCacheDir(); legacy != "" {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if _, err := os.Stat(legacy); err == nil {
+				return legacy
+			}
+		}
+	}
+	return dir
+}
+
+func defaultCacheDir() string {
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "k3p")
+	}
+	return filepath.Join(userCache, "k3p")
+}
+
+// legacyCacheDir is the cache of k3p versions before the cache location was configurable
+func legacyCacheDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, LocalChartLocation)
+}
+
+// migrateLegacyCache moves the cache of older k3p versions to the default cache directory, once
+func migrateLegacyCache() {
+	if cacheDirFlag != "" || os.Getenv(cacheDirEnv) != "" {
+		return
+	}
+	dir, legacy := defaultCacheDir(), legacyCacheDir()
+	if legacy == "" {
+		return
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err == nil {
+		err = os.Rename(legacy, dir)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to move the cache from %s to %s, still using the old location: %v", legacy, dir, err)
+		return
+	}
+	logrus.Infof("Moved the cache from %s to %s", legacy, dir)
+}
+
+// cachedPackages lists the package directories in the cache with their indexed version
+func cachedPackages() ([]cachedPackage, error) {
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(cacheDir())
+	if os.IsNotExist(err) {
+		return []cachedPackage{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := []cachedPackage{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		p := cachedPackage{Name: entry.Name()}
+		if indexed, ok := index.Find(p.Name); ok {
+			p.Version = indexed.Version
+			p.Indexed = true
+		}
+		if p.Size, err = dirSize(packageDir(p.Name)); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// clearCacheDir removes everything in the cache but the lock file, which other k3p processes may be waiting for
+func clearCacheDir() error {
+	entries, err := ioutil.ReadDir(cacheDir())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == cacheLockFile {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cacheDir(), entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCachedPackage removes a package from the cache and the index
+func removeCachedPackage(index *Index, name string) error {
+	logrus.Infof("Removing package %s", name)
+	if err := os.RemoveAll(packageDir(name)); err != nil {
+		return err
+	}
+	var kept []IndexPackage
+	for _, p := range index.Packages {
+		if p.Name != name {
+			kept = append(kept, p)
+		}
+	}
+	index.Packages = kept
+	touched("deleted", "Package", "", name)
+	return nil
+}
+
+// verifyCachedPackage returns what is missing or broken in a cached package. Locked packages are compared to the lockfile.
+func verifyCachedPackage(name string, lock *Lockfile) ([]string, error) {
+	var problems []string
+	if _, err := os.Stat(packageDir(name)); os.IsNotExist(err) {
+		return []string{"not cached"}, nil
+	}
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := index.Find(name); !ok {
+		problems = append(problems, "not in the package index")
+	}
+	if _, err := loadPackageYaml(name); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(chartDir(name), "Chart.yaml")); err != nil {
+		problems = append(problems, "chart is missing Chart.yaml")
+	}
+	if len(problems) == 0 && lock != nil {
+		if _, locked := lock.Find(name); locked {
+			if err := lock.Verify(name); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	return problems, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// formatSize formats a number of bytes for humans
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
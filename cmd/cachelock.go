@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	cacheLockFile = ".lock"
+
+	// cacheLockAnnotation marks how a command locks the cache, commands without it take a shared lock
+	cacheLockAnnotation = "k3p.io/cache-lock"
+	cacheLockExclusive  = "exclusive"
+	cacheLockNone       = "none"
+
+	// commands with this flag set import a bundle into the cache and lock it exclusively
+	fromBundleFlag = "from-bundle"
+
+	cacheLockRetryInterval = 100 * time.Millisecond
+)
+
+var (
+	lockTimeout time.Duration
+
+	// heldCacheLock is the lock taken for the running command
+	heldCacheLock *os.File
+)
+
+func init() {
+	rootCmd.PersistentFlags().DurationVarP(&lockTimeout, "lock-timeout", "", time.Minute, "how long to wait for other k3p processes using the package cache")
+}
+
+// lockCacheFor locks the cache as the annotation of a command asks for. The lock is taken once for the whole command,
+// a command that imports a bundle locks exclusively from the start instead of upgrading a shared lock.
+func lockCacheFor(cmd *cobra.Command) error {
+	if flag := cmd.Flags().Lookup(fromBundleFlag); flag != nil && flag.Value.String() != "" {
+		return lockCache(true)
+	}
+	switch cmd.Annotations[cacheLockAnnotation] {
+	case cacheLockNone:
+		return nil
+	case cacheLockExclusive:
+		return lockCache(true)
+	default:
+		return lockCache(false)
+	}
+}
+
+// lockCache takes an advisory lock on the cache, exclusive for commands that change it and shared for commands that
+// read it. It waits up to --lock-timeout for other k3p processes to release their lock. The lock is held until
+// unlockCache is called or k3p exits.
+func lockCache(exclusive bool) error {
+	if err := os.MkdirAll(cacheDir(), 0755); err != nil && exclusive {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(cacheDir(), cacheLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		if !exclusive {
+			// a read-only cache can't change while it is read
+			logrus.Debugf("Reading the cache without a lock: %v", err)
+			return nil
+		}
+		return errors.Wrap(err, "failed to lock the cache")
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	waiting := false
+	for {
+		locked, err := tryLockFile(file, exclusive)
+		if err != nil {
+			file.Close()
+			return errors.Wrap(err, "failed to lock the cache")
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return fmt.Errorf("timed out after %v waiting for %s to release the cache %s", lockTimeout, lockHolder(file), cacheDir())
+		}
+		if !waiting {
+			waiting = true
+			logrus.Infof("Waiting for %s to release the cache %s", lockHolder(file), cacheDir())
+		}
+		time.Sleep(cacheLockRetryInterval)
+	}
+
+	// the PID names the holder to processes waiting for the lock. With shared locks it is the last reader.
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	heldCacheLock = file
+	return nil
+}
+
+// unlockCache releases the lock taken by lockCache
+func unlockCache() {
+	if heldCacheLock == nil {
+		return
+	}
+	unlockFile(heldCacheLock)
+	heldCacheLock.Close()
+	heldCacheLock = nil
+}
+
+// lockHolder describes the process holding the lock, as recorded in the lock file
+func lockHolder(file *os.File) string {
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return "another k3p process"
+	}
+	if pid := strings.TrimSpace(string(data)); pid != "" {
+		return "k3p process " + pid
+	}
+	return "another k3p process"
+}
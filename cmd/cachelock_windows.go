@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLockFile locks a file with LockFileEx without blocking, it returns false if another process holds a conflicting lock
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, lockRange())
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, lockRange())
+}
+
+// lockRange is a byte past the PID in the lock file, so processes waiting for the lock can still read the PID
+func lockRange() *windows.Overlapped {
+	return &windows.Overlapped{OffsetHigh: 1}
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configFileEnv = "K3P_CONFIG"
+
+	defaultConnectTimeout = 10 * time.Second
+	defaultHTTPTimeout    = 5 * time.Minute
+	defaultHTTPRetries    = 3
+)
+
+var (
+	// config is read from --config before a command runs
+	config    = &Config{}
+	configErr error
+)
+
+// Config is the k3p config file, it lists the package repositories and how to fetch from them
+type Config struct {
+	HTTP         HTTPConfig   `json:"http,omitempty"`
+	Repositories []Repository `json:"repositories,omitempty"`
+	Rewrites     []URLRewrite `json:"rewrites,omitempty"`
+}
+
+// HTTPConfig tunes how indexes, packages, charts and patches are fetched
+type HTTPConfig struct {
+	ConnectTimeout string `json:"connectTimeout,omitempty"`
+	Timeout        string `json:"timeout,omitempty"`
+	Retries        *int   `json:"retries,omitempty"`
+	CAFile         string `json:"caFile,omitempty"`
+}
+
+// Repository is a package index. Mirrors are tried in order if the index can't be fetched from URL. Its TLS settings
+// and credentials are used for the URLs of its packages below the directory of the index or a mirror. Credentials may
+// reference environment variables as ${VAR}.
+type Repository struct {
+	Name                  string   `json:"name"`
+	URL                   string   `json:"url"`
+	Mirrors               []string `json:"mirrors,omitempty"`
+	CAFile                string   `json:"caFile,omitempty"`
+	InsecureSkipTLSVerify bool     `json:"insecureSkipTLSVerify,omitempty"`
+	Username              string   `json:"username,omitempty"`
+	Password              string   `json:"password,omitempty"`
+	Token                 string   `json:"token,omitempty"`
+	CertFile              string   `json:"certFile,omitempty"`
+	KeyFile               string   `json:"keyFile,omitempty"`
+}
+
+// URLRewrite replaces the prefix From of every fetched URL with To, e.g. to fetch through an internal cache
+type URLRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// defaultConfigFile is $K3P_CONFIG or config.yaml in the k3p directory of $XDG_CONFIG_HOME
+func defaultConfigFile() string {
+	if file := os.Getenv(configFileEnv); file != "" {
+		return file
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "k3p", "config.yaml")
+}
+
+// loadConfig reads the config file. A missing config file is only an error if it was given explicitly.
+func loadConfig(file string) (*Config, error) {
+	explicit := file != ""
+	if !explicit {
+		file = defaultConfigFile()
+	}
+	result := &Config{}
+	if file == "" {
+		return result, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !explicit {
+		return result, nil
+	} else if err != nil {
+		return nil, withExitCode(exitUsage, errors.Wrap(err, "failed to read config file"))
+	}
+	if err := yaml.Unmarshal(data, result); err != nil {
+		return nil, withExitCode(exitValidation, errors.Wrapf(err, "failed to parse config file %s", file))
+	}
+	if err := result.validate(); err != nil {
+		return nil, withExitCode(exitValidation, errors.Wrapf(err, "invalid config file %s", file))
+	}
+	return result, nil
+}
+
+func (c *Config) validate() error {
+	for _, d := range []string{c.HTTP.ConnectTimeout, c.HTTP.Timeout} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return errors.Errorf("invalid timeout %q: %v", d, err)
+		}
+	}
+	names := map[string]bool{}
+	for _, r := range c.Repositories {
+		if r.Name == "" || r.URL == "" {
+			return errors.New("repositories need a name and a url")
+		}
+		if names[r.Name] {
+			return errors.Errorf("repository %s is defined twice", r.Name)
+		}
+		names[r.Name] = true
+		if (r.CertFile == "") != (r.KeyFile == "") {
+			return errors.Errorf("repository %s needs both certFile and keyFile", r.Name)
+		}
+	}
+	for _, r := range c.Rewrites {
+		if r.From == "" || r.To == "" {
+			return errors.New("rewrites need a from and a to URL")
+		}
+	}
+	return nil
+}
+
+// rewriteURL applies the first rewrite matching a URL
+func (c *Config) rewriteURL(rawURL string) string {
+	for _, r := range c.Rewrites {
+		if strings.HasPrefix(rawURL, r.From) {
+			return r.To + strings.TrimPrefix(rawURL, r.From)
+		}
+	}
+	return rawURL
+}
+
+// repositories returns the configured repositories, or the default index without a config file
+func repositories() []Repository {
+	if len(config.Repositories) > 0 {
+		return config.Repositories
+	}
+	return []Repository{{Name: "default", URL: IndexURL}}
+}
+
+func (h HTTPConfig) connectTimeout() time.Duration {
+	return parseDurationOr(h.ConnectTimeout, defaultConnectTimeout)
+}
+
+func (h HTTPConfig) timeout() time.Duration {
+	return parseDurationOr(h.Timeout, defaultHTTPTimeout)
+}
+
+func (h HTTPConfig) retries() int {
+	if h.Retries == nil {
+		return defaultHTTPRetries
+	}
+	return *h.Retries
+}
+
+// parseDurationOr parses a duration validated with the config, empty durations are the default
+func parseDurationOr(d string, def time.Duration) time.Duration {
+	if result, err := time.ParseDuration(d); err == nil {
+		return result
+	}
+	return def
+}
@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3p/pkg/registry"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	crdReleaseLabel = "k3p.io/crds-of-release"
+	crdDataKey      = "crds.yaml"
+	// crdArchiveKey holds the gzipped CRD manifest in binaryData, CRD sets of some packages are close to the size limit
+	// of a ConfigMap uncompressed
+	crdArchiveKey = "crds.yaml.gz"
+)
+
+// reconcileCRDs applies the CRD manifest of a package and waits for the CRDs to be established.
+// Changes that would make stored custom resources unreadable or invalid are refused unless force is set.
+func reconcileCRDs(crdManifest string, force bool, timeout time.Duration) error {
+	if strings.TrimSpace(crdManifest) == "" {
+		return nil
+	}
+
+	crds, err := parseCRDs(crdManifest)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, crd := range crds {
+		name := nestedString(crd, "metadata", "name")
+		output, err := kubectl(nil, "get", "customresourcedefinition", name, "--ignore-not-found", "-o", "json")
+		if err != nil {
+			return err
+		}
+		if len(output) == 0 {
+			continue
+		}
+		existing := map[string]interface{}{}
+		if err := json.Unmarshal(output, &existing); err != nil {
+			return err
+		}
+		for _, p := range unsafeCRDChanges(existing, crd) {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, p))
+		}
+	}
+
+	if len(problems) > 0 {
+		if !force {
+			return validationError("refusing to update CRDs, the following changes are unsafe (use --force-crd to apply them anyway):\n  %s",
+				strings.Join(problems, "\n  "))
+		}
+		logrus.Warnf("Applying unsafe CRD changes:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	logrus.Infof("Upgrading CRDs")
+	if _, err := kubectl([]byte(crdManifest), "apply", "-f", "-"); err != nil {
+		return err
+	}
+
+	resources, err := crdResources(crdManifest)
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		touched("applied", r.Kind, r.Namespace, r.Name)
+	}
+	return waitForResources(resources, timeout)
+}
+
+func parseCRDs(crdManifest string) ([]map[string]interface{}, error) {
+	var result []map[string]interface{}
+	for _, doc := range registry.SplitDocuments([]byte(crdManifest)) {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		if obj["kind"] == "CustomResourceDefinition" {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+// unsafeCRDChanges compares the CRD in the cluster with its new definition and describes the changes
+// that remove stored versions or narrow the schema of a version
+func unsafeCRDChanges(existing, updated map[string]interface{}) []string {
+	var result []string
+
+	oldVersions := crdVersions(existing)
+	newVersions := crdVersions(updated)
+
+	stored, _ := nested(existing, "status", "storedVersions").([]interface{})
+	for _, s := range stored {
+		version, _ := s.(string)
+		v, ok := newVersions[version]
+		if !ok {
+			result = append(result, fmt.Sprintf("version %s is removed but is still listed in status.storedVersions", version))
+		} else if !v.served {
+			result = append(result, fmt.Sprintf("version %s is no longer served but is still listed in status.storedVersions", version))
+		}
+	}
+
+	var names []string
+	for name := range oldVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		newVersion, ok := newVersions[name]
+		if !ok || oldVersions[name].schema == nil || newVersion.schema == nil {
+			continue
+		}
+		for _, p := range narrowedSchema("", oldVersions[name].schema, newVersion.schema) {
+			result = append(result, fmt.Sprintf("version %s: %s", name, p))
+		}
+	}
+
+	return result
+}
+
+type crdVersion struct {
+	served bool
+	schema map[string]interface{}
+}
+
+// crdVersions returns the versions of a v1 or v1beta1 CRD with their schema
+func crdVersions(crd map[string]interface{}) map[string]crdVersion {
+	result := map[string]crdVersion{}
+	commonSchema, _ := nested(crd, "spec", "validation", "openAPIV3Schema").(map[string]interface{})
+
+	versions, _ := nested(crd, "spec", "versions").([]interface{})
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		served, _ := version["served"].(bool)
+		schema, _ := nested(version, "schema", "openAPIV3Schema").(map[string]interface{})
+		if schema == nil {
+			schema = commonSchema
+		}
+		result[nestedString(version, "name")] = crdVersion{
+			served: served,
+			schema: schema,
+		}
+	}
+
+	if name := nestedString(crd, "spec", "version"); name != "" && len(versions) == 0 {
+		result[name] = crdVersion{
+			served: true,
+			schema: commonSchema,
+		}
+	}
+
+	return result
+}
+
+// narrowedSchema returns the changes of a structural schema that can make existing objects invalid
+func narrowedSchema(path string, old, updated map[string]interface{}) []string {
+	var result []string
+	field := path
+	if field == "" {
+		field = "."
+	}
+
+	if oldType, newType := old["type"], updated["type"]; oldType != nil && newType != nil && oldType != newType {
+		result = append(result, fmt.Sprintf("%s changed type from %v to %v", field, oldType, newType))
+		return result
+	}
+
+	oldRequired := stringSet(old["required"])
+	for _, r := range sortedKeys(stringSet(updated["required"])) {
+		if !oldRequired[r] {
+			result = append(result, fmt.Sprintf("%s.%s became required", strings.TrimSuffix(path, "."), r))
+		}
+	}
+
+	if oldEnum, ok := old["enum"].([]interface{}); ok {
+		newEnum, _ := updated["enum"].([]interface{})
+		for _, e := range oldEnum {
+			found := len(newEnum) == 0
+			for _, n := range newEnum {
+				if reflect.DeepEqual(e, n) {
+					found = true
+				}
+			}
+			if !found {
+				result = append(result, fmt.Sprintf("%s no longer allows %v", field, e))
+			}
+		}
+	} else if _, ok := updated["enum"]; ok {
+		result = append(result, fmt.Sprintf("%s is restricted to an enum", field))
+	}
+
+	for _, limit := range []string{"maximum", "maxLength", "maxItems", "maxProperties", "minimum", "minLength", "minItems", "minProperties"} {
+		if !limitNarrowed(old[limit], updated[limit], strings.HasPrefix(limit, "max")) {
+			continue
+		}
+		if old[limit] == nil {
+			result = append(result, fmt.Sprintf("%s %s set to %v", field, limit, updated[limit]))
+		} else {
+			result = append(result, fmt.Sprintf("%s %s changed from %v to %v", field, limit, old[limit], updated[limit]))
+		}
+	}
+
+	oldProps, _ := old["properties"].(map[string]interface{})
+	newProps, _ := updated["properties"].(map[string]interface{})
+	preserveUnknown, _ := updated["x-kubernetes-preserve-unknown-fields"].(bool)
+	for _, name := range sortedKeys(oldProps) {
+		oldProp, _ := oldProps[name].(map[string]interface{})
+		newProp, ok := newProps[name].(map[string]interface{})
+		if !ok {
+			if !preserveUnknown && updated["additionalProperties"] == nil {
+				result = append(result, fmt.Sprintf("%s.%s was removed", strings.TrimSuffix(path, "."), name))
+			}
+			continue
+		}
+		result = append(result, narrowedSchema(path+"."+name, oldProp, newProp)...)
+	}
+
+	oldItems, _ := old["items"].(map[string]interface{})
+	newItems, _ := updated["items"].(map[string]interface{})
+	if oldItems != nil && newItems != nil {
+		result = append(result, narrowedSchema(path+"[]", oldItems, newItems)...)
+	}
+
+	return result
+}
+
+// limitNarrowed returns true if a numeric limit was added, or lowered for an upper or raised for a lower limit
+func limitNarrowed(old, updated interface{}, upper bool) bool {
+	newLimit, ok := updated.(float64)
+	if !ok {
+		return false
+	}
+	oldLimit, ok := old.(float64)
+	if !ok {
+		return true
+	}
+	if upper {
+		return newLimit < oldLimit
+	}
+	return newLimit > oldLimit
+}
+
+func stringSet(v interface{}) map[string]bool {
+	result := map[string]bool{}
+	values, _ := v.([]interface{})
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result[s] = true
+		}
+	}
+	return result
+}
+
+func sortedKeys(m interface{}) []string {
+	var result []string
+	switch v := m.(type) {
+	case map[string]bool:
+		for k := range v {
+			result = append(result, k)
+		}
+	case map[string]string:
+		for k := range v {
+			result = append(result, k)
+		}
+	case map[string]int:
+		for k := range v {
+			result = append(result, k)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func nested(obj map[string]interface{}, fields ...string) interface{} {
+	var current interface{} = obj
+	for _, field := range fields {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[field]
+	}
+	return current
+}
+
+func nestedString(obj map[string]interface{}, fields ...string) string {
+	s, _ := nested(obj, fields...).(string)
+	return s
+}
+
+// saveCRDManifest stores the gzipped CRD manifest a revision of a release was installed with, so a rollback can restore
+// it
+func saveCRDManifest(release, namespace, crdManifest string) (string, error) {
+	if strings.TrimSpace(crdManifest) == "" {
+		return "", nil
+	}
+	digest := sha256Hex([]byte(crdManifest))
+
+	metadata := map[string]interface{}{
+		"name": crdConfigMapName(release, digest),
+		"labels": map[string]string{
+			crdReleaseLabel: release,
+		},
+	}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	archive := &bytes.Buffer{}
+	gz := gzip.NewWriter(archive)
+	if _, err := gz.Write([]byte(crdManifest)); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata,
+		"binaryData": map[string][]byte{
+			crdArchiveKey: archive.Bytes(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := saveObject(manifest); err != nil {
+		return "", errors.Wrapf(err, "saving the CRDs of release %s", release)
+	}
+	return digest, nil
+}
+
+// loadCRDManifest returns a CRD manifest stored by saveCRDManifest
+func loadCRDManifest(release, namespace, digest string) (string, error) {
+	output, err := kubectl(nil, namespaceArgs(namespace, "get", "configmap", crdConfigMapName(release, digest), "-o", "json")...)
+	if err != nil {
+		return "", err
+	}
+	configMap := struct {
+		Data       map[string]string `json:"data"`
+		BinaryData map[string][]byte `json:"binaryData"`
+	}{}
+	if err := json.Unmarshal(output, &configMap); err != nil {
+		return "", err
+	}
+	archive, ok := configMap.BinaryData[crdArchiveKey]
+	if !ok {
+		// stored uncompressed by earlier versions
+		return configMap.Data[crdDataKey], nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	data, err := ioutil.ReadAll(gz)
+	return string(data), err
+}
+
+func crdConfigMapName(release, digest string) string {
+	return fmt.Sprintf("k3p-crds-%s-%s", release, shortDigest(digest))
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const widgetCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              size:
+                type: integer
+                maximum: 10
+              color:
+                type: string
+                enum: [red, blue]
+status:
+  storedVersions: [v1]
+  conditions:
+  - type: Established
+    status: "True"
+`
+
+func parseCRD(t *testing.T, manifest string) map[string]interface{} {
+	crd := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(manifest), &crd); err != nil {
+		t.Fatal(err)
+	}
+	return crd
+}
+
+func TestUnsafeCRDChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		updated  string
+		expected []string
+	}{
+		{"unchanged", widgetCRD, nil},
+		{"widened", strings.Replace(widgetCRD, "maximum: 10", "maximum: 20", 1), nil},
+		{"new version", strings.Replace(widgetCRD, "  versions:\n", "  versions:\n  - name: v2\n    served: true\n", 1), nil},
+		{
+			"stored version removed",
+			strings.Replace(widgetCRD, "- name: v1", "- name: v2", 1),
+			[]string{"version v1 is removed but is still listed in status.storedVersions"},
+		},
+		{
+			"stored version not served",
+			strings.Replace(widgetCRD, "served: true", "served: false", 1),
+			[]string{"version v1 is no longer served but is still listed in status.storedVersions"},
+		},
+		{
+			"type changed",
+			strings.Replace(widgetCRD, "type: integer", "type: string", 1),
+			[]string{"version v1: .spec.size changed type from integer to string"},
+		},
+		{
+			"limit lowered",
+			strings.Replace(widgetCRD, "maximum: 10", "maximum: 5", 1),
+			[]string{"version v1: .spec.size maximum changed from 10 to 5"},
+		},
+		{
+			"enum narrowed",
+			strings.Replace(widgetCRD, "enum: [red, blue]", "enum: [red]", 1),
+			[]string{"version v1: .spec.color no longer allows blue"},
+		},
+		{
+			"field removed",
+			strings.Replace(widgetCRD, "              color:\n                type: string\n                enum: [red, blue]\n", "", 1),
+			[]string{"version v1: .spec.color was removed"},
+		},
+		{
+			"field required",
+			strings.Replace(widgetCRD, "            type: object\n            properties:", "            type: object\n            required: [size]\n            properties:", 1),
+			[]string{"version v1: .spec.size became required"},
+		},
+	}
+	for _, test := range tests {
+		actual := unsafeCRDChanges(parseCRD(t, widgetCRD), parseCRD(t, test.updated))
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+// crdKubectl returns widgetCRD as the CRD in the cluster and records applied manifests
+const crdKubectl = `dir=$(dirname "$0")
+case "$*" in
+  "get customresourcedefinition"*|"get CustomResourceDefinition"*) cat "$dir/crd.yaml";;
+  "apply -f -") cat > "$dir/applied";;
+  *) exit 1;;
+esac
+`
+
+func TestReconcileCRDs(t *testing.T) {
+	withFakeCluster(t, crdKubectl, func(cache string) {
+		bin := filepath.Join(filepath.Dir(cache), "bin")
+		data, err := yaml.YAMLToJSON([]byte(widgetCRD))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(bin, "crd.yaml"), string(data))
+		applied := filepath.Join(bin, "applied")
+
+		unsafe := strings.Replace(widgetCRD, "maximum: 10", "maximum: 5", 1)
+		err = reconcileCRDs(unsafe, false, time.Second)
+		if err == nil || exitCode(err) != exitValidation || !strings.Contains(err.Error(), "maximum changed from 10 to 5") {
+			t.Errorf("expected the unsafe change to be refused, got %v", err)
+		}
+		if _, err := os.Stat(applied); !os.IsNotExist(err) {
+			t.Error("a refused CRD change was applied")
+		}
+
+		for _, test := range []struct {
+			manifest string
+			force    bool
+		}{
+			{strings.Replace(widgetCRD, "maximum: 10", "maximum: 20", 1), false},
+			{unsafe, true},
+		} {
+			os.Remove(applied)
+			if err := reconcileCRDs(test.manifest, test.force, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := ioutil.ReadFile(applied); err != nil || string(data) != test.manifest {
+				t.Errorf("expected the CRDs to be applied, got %q: %v", data, err)
+			}
+		}
+	})
+}
+
+// crdConfigMapKubectl stores created ConfigMaps and returns the last one
+const crdConfigMapKubectl = `dir=$(dirname "$0")
+case "$*" in
+  "create -f -") cat > "$dir/configmap";;
+  "get configmap"*) cat "$dir/configmap";;
+  *) exit 1;;
+esac
+`
+
+func TestSaveCRDManifest(t *testing.T) {
+	withFakeCluster(t, crdConfigMapKubectl, func(cache string) {
+		// larger than the 256 KiB annotations client-side apply writes can hold
+		manifest := strings.Repeat(widgetCRD+"---\n", 400)
+		digest, err := saveCRDManifest("foo", "default", manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := loadCRDManifest("foo", "default", digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded != manifest {
+			t.Error("the loaded CRD manifest differs from the saved one")
+		}
+	})
+}
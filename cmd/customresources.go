@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// serverManagedMetadata is removed from exported objects so they can be created again
+	serverManagedMetadata = []string{
+		"uid",
+		"resourceVersion",
+		"generation",
+		"creationTimestamp",
+		"deletionTimestamp",
+		"deletionGracePeriodSeconds",
+		"managedFields",
+		"selfLink",
+		"ownerReferences",
+	}
+)
+
+// crdKind identifies the resource type defined by a CRD
+type crdKind struct {
+	Name   string
+	Group  string
+	Kind   string
+	Plural string
+}
+
+// Resource returns the fully qualified resource name used with kubectl get
+func (c crdKind) Resource() string {
+	return c.Plural + "." + c.Group
+}
+
+// customResources are the objects of one CRD found in the cluster
+type customResources struct {
+	CRD   crdKind
+	Items []map[string]interface{}
+}
+
+func crdKinds(crdManifest string) ([]crdKind, error) {
+	crds, err := parseCRDs(crdManifest)
+	if err != nil {
+		return nil, err
+	}
+	var result []crdKind
+	for _, crd := range crds {
+		result = append(result, crdKind{
+			Name:   nestedString(crd, "metadata", "name"),
+			Group:  nestedString(crd, "spec", "group"),
+			Kind:   nestedString(crd, "spec", "names", "kind"),
+			Plural: nestedString(crd, "spec", "names", "plural"),
+		})
+	}
+	return result, nil
+}
+
+// listCustomResources returns the custom resources of every CRD in the manifest in all namespaces.
+// CRDs that don't exist in the cluster are skipped.
+func listCustomResources(crdManifest string) ([]customResources, error) {
+	kinds, err := crdKinds(crdManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []customResources
+	for _, kind := range kinds {
+		output, err := kubectl(nil, "get", "customresourcedefinition", kind.Name, "--ignore-not-found", "-o", "name")
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(output)) == "" {
+			continue
+		}
+
+		output, err = kubectl(nil, "get", kind.Resource(), "--all-namespaces", "-o", "json")
+		if err != nil {
+			return nil, err
+		}
+		list := struct {
+			Items []map[string]interface{} `json:"items"`
+		}{}
+		if err := json.Unmarshal(output, &list); err != nil {
+			return nil, err
+		}
+		result = append(result, customResources{
+			CRD:   kind,
+			Items: list.Items,
+		})
+	}
+	return result, nil
+}
+
+// countByNamespace returns how many custom resources exist per namespace, cluster scoped ones are counted as "(cluster)"
+func (c customResources) countByNamespace() map[string]int {
+	result := map[string]int{}
+	for _, item := range c.Items {
+		ns := nestedString(item, "metadata", "namespace")
+		if ns == "" {
+			ns = "(cluster)"
+		}
+		result[ns]++
+	}
+	return result
+}
+
+func printCustomResourceCounts(resources []customResources) int {
+	total := 0
+	for _, r := range resources {
+		counts := r.countByNamespace()
+		logrus.Infof("%s: %d custom resources", r.CRD.Resource(), len(r.Items))
+		for _, ns := range sortedKeys(counts) {
+			logrus.Infof("  %s: %d", ns, counts[ns])
+		}
+		total += len(r.Items)
+	}
+	return total
+}
+
+// cleanObject removes status and server managed metadata so an exported object can be created in a cluster again
+func cleanObject(obj map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range obj {
+		if k != "status" {
+			result[k] = v
+		}
+	}
+
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		cleaned := map[string]interface{}{}
+		for k, v := range metadata {
+			cleaned[k] = v
+		}
+		for _, field := range serverManagedMetadata {
+			delete(cleaned, field)
+		}
+		if annotations, ok := cleaned["annotations"].(map[string]interface{}); ok {
+			kept := map[string]interface{}{}
+			for k, v := range annotations {
+				if k != "kubectl.kubernetes.io/last-applied-configuration" {
+					kept[k] = v
+				}
+			}
+			if len(kept) == 0 {
+				delete(cleaned, "annotations")
+			} else {
+				cleaned["annotations"] = kept
+			}
+		}
+		result["metadata"] = cleaned
+	}
+	return result
+}
+
+// objectsYaml marshals objects as a multi-document YAML manifest
+func objectsYaml(objects []map[string]interface{}) ([]byte, error) {
+	var docs []string
+	for _, obj := range objects {
+		data, err := yaml.Marshal(cleanObject(obj))
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, string(data))
+	}
+	return []byte(strings.Join(docs, "---\n")), nil
+}
+
+// confirm asks the user a yes/no question on the terminal. It fails if stdin is not a terminal.
+func confirm(question string) (bool, error) {
+	if !isTerminal(os.Stdin) {
+		return false, usageError("not running interactively, use --yes to confirm: %s", question)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func sortedCustomResources(resources []customResources) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, r := range resources {
+		items := append([]map[string]interface{}{}, r.Items...)
+		sort.Slice(items, func(i, j int) bool {
+			return objectKey(items[i]) < objectKey(items[j])
+		})
+		result = append(result, items...)
+	}
+	return result
+}
+
+func objectKey(obj map[string]interface{}) string {
+	return nestedString(obj, "metadata", "namespace") + "/" + nestedString(obj, "metadata", "name")
+}
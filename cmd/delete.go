@@ -1,69 +1,142 @@
 package cmd
 
 import (
-	"fmt"
-	"io/ioutil"
-	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"sigs.k8s.io/yaml"
 )
 
 var (
 	deleteCustomOptions []string
+	deleteCascade       bool
+	deleteForce         bool
+	deleteNamespace     string
 )
 
 var deleteCmd = &cobra.Command{
 	Use:   "delete",
-	Short: "Delete a package",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			fmt.Println("Exact one argument is required")
-			os.Exit(1)
-		}
-		packageName := args[0]
+	Short: "Delete a package release",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		releaseName := args[0]
+		cmdResult.Action = "delete"
 
-		packagePath := filepath.Join(os.Getenv("HOME"), LocalChartLocation, args[0], "package.yaml")
-		if _, err := os.Stat(packagePath); err != nil {
-			fmt.Printf("Can't locate package %v. Run `k3p update`.\n", args[0])
+		release, err := findRelease(releaseName, deleteNamespace)
+		if err != nil {
+			return err
+		}
+		if release == nil {
+			release = &Release{
+				Name:      releaseName,
+				Namespace: deleteNamespace,
+				Package:   releaseName,
+			}
 		}
 
-		packageYamlData, err := ioutil.ReadFile(packagePath)
+		releases, err := listReleases()
 		if err != nil {
-			handleError(err)
-		}
-		packageYaml := &PackageYaml{}
-		if err := yaml.Unmarshal(packageYamlData, packageYaml); err != nil {
-			handleError(err)
+			return err
 		}
-		for _, deleteCommand := range packageYaml.PreDeleteCommand {
-			args := strings.Fields(deleteCommand)
-			if len(args) > 1 {
-				c := exec.Command(args[0], args[1:]...)
-				fmt.Println(c.Args)
-				if output, err := c.CombinedOutput(); err != nil {
-					fmt.Println(string(output))
-					handleError(err)
-				}
+
+		required := requiredBy(*release, releases)
+		if len(required) > 0 && !deleteForce && !deleteCascade {
+			var names []string
+			for _, r := range required {
+				names = append(names, r.Name)
 			}
+			return validationError("package %s is required by %s. Use --cascade to delete them as well or --force to delete it anyway",
+				release.Package, strings.Join(names, ", "))
 		}
 
-		var options []string
-		if len(customOptions) > 0 {
-			options = append(options, deleteCustomOptions...)
+		if deleteCascade {
+			deleted := map[string]bool{releaseKey(*release): true}
+			if err := deleteDependents(*release, releases, deleted); err != nil {
+				return err
+			}
 		}
-		options = append(append([]string{"delete"}, customOptions...), packageName)
-		helmCmd := exec.Command("helm", options...)
-		if output, err := helmCmd.CombinedOutput(); err != nil {
-			fmt.Println(string(output))
-			handleError(err)
+
+		if err := deletePackage(*release); err != nil {
+			return err
 		}
+		return printResult(nil)
 	},
 }
 
 func init() {
 	deleteCmd.Flags().StringArrayVarP(&deleteCustomOptions, "custom-options", "", nil, "custom delete option passed through helm delete")
+	deleteCmd.Flags().BoolVarP(&deleteCascade, "cascade", "", false, "also delete the packages that depend on this package")
+	deleteCmd.Flags().BoolVarP(&deleteForce, "force", "", false, "delete the package even if other packages depend on it")
+	deleteCmd.Flags().StringVarP(&deleteNamespace, "namespace", "n", "", "namespace of the release, defaults to the namespace it was installed into")
+}
+
+func releaseKey(release Release) string {
+	return release.Namespace + "/" + release.Name
+}
+
+// requiredBy returns the releases that depend on the package of release, unless another release of that package remains installed
+func requiredBy(release Release, releases []Release) []Release {
+	for _, r := range releases {
+		if r.Package == release.Package && releaseKey(r) != releaseKey(release) {
+			return nil
+		}
+	}
+	return dependents(release.Package, releases)
+}
+
+// deleteDependents deletes every release that depends on release, the ones depending on them first
+func deleteDependents(release Release, releases []Release, deleted map[string]bool) error {
+	for _, dependent := range requiredBy(release, releases) {
+		if deleted[releaseKey(dependent)] {
+			continue
+		}
+		deleted[releaseKey(dependent)] = true
+		if err := deleteDependents(dependent, releases, deleted); err != nil {
+			return err
+		}
+		logrus.Infof("Deleting dependent release %s", dependent.Name)
+		if err := deletePackage(dependent); err != nil {
+			return errors.Wrapf(err, "deleting dependent release %s", dependent.Name)
+		}
+	}
+	return nil
+}
+
+func deletePackage(release Release) error {
+	packageYaml, err := loadPackageYaml(release.Package)
+	if err != nil {
+		return err
+	}
+
+	hookCtx := hookContext{
+		Phase:     "preDelete",
+		Package:   release.Package,
+		Version:   release.Version,
+		Release:   release.Name,
+		Namespace: release.Namespace,
+	}
+	if err := runHooks(preDeleteHooks(packageYaml), hookCtx); err != nil {
+		return err
+	}
+
+	options := append(namespaceArgs(release.Namespace, append([]string{"delete"}, deleteCustomOptions...)...), release.Name)
+	helmCmd := exec.Command("helm", options...)
+	start := time.Now()
+	output, err := helmCmd.CombinedOutput()
+	logCommand("helm", options, start, err)
+	logrus.Debug(strings.TrimSpace(string(output)))
+	if err != nil {
+		return clusterError(errors.Wrapf(err, "helm delete of release %s failed", release.Name))
+	}
+
+	if err := deleteRelease(release); err != nil {
+		return err
+	}
+	touched("deleted", "Release", release.Namespace, release.Name)
+
+	hookCtx.Phase = "postDelete"
+	return runHooks(packageYaml.Hooks.PostDelete, hookCtx)
 }
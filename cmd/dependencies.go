@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+type dependencyResolver struct {
+	index     *Index
+	installed map[string]Release
+	state     map[string]int
+	order     []string
+}
+
+// resolveDependencies returns the packages that have to be installed before packageName, in install order.
+// Dependencies that are already installed with a matching version are left out.
+func resolveDependencies(packageName string) ([]string, error) {
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	releases, err := listReleases()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &dependencyResolver{
+		index:     index,
+		installed: map[string]Release{},
+		state:     map[string]int{},
+	}
+	for _, release := range releases {
+		r.installed[release.Package] = release
+	}
+
+	if err := r.visit(packageName, nil); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, name := range r.order {
+		if _, ok := r.installed[name]; ok || name == packageName {
+			continue
+		}
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+func (r *dependencyResolver) visit(packageName string, path []string) error {
+	path = append(path, packageName)
+	switch r.state[packageName] {
+	case visiting:
+		return validationError("dependency cycle detected: %s", strings.Join(path, " -> "))
+	case visited:
+		return nil
+	}
+	r.state[packageName] = visiting
+
+	if _, err := os.Stat(filepath.Join(packageDir(packageName), "package.yaml")); err != nil {
+		if _, ok := r.installed[packageName]; !ok {
+			return notFoundError("can't locate package %s required by %s. Run `k3p update`", packageName, strings.Join(path[:len(path)-1], " -> "))
+		}
+	} else {
+		packageYaml, err := loadPackageYaml(packageName)
+		if err != nil {
+			return err
+		}
+		for _, dep := range packageYaml.DependsOn {
+			if err := r.check(packageName, dep); err != nil {
+				return err
+			}
+			if err := r.visit(dep.Name, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.state[packageName] = visited
+	r.order = append(r.order, packageName)
+	return nil
+}
+
+// check verifies that the installed version of a dependency, or the version that would be installed, matches the constraint
+func (r *dependencyResolver) check(packageName string, dep Dependency) error {
+	if dep.Version == "" {
+		return nil
+	}
+
+	state := "installed"
+	version := r.installed[dep.Name].Version
+	if _, ok := r.installed[dep.Name]; !ok {
+		state = "available"
+		p, _ := r.index.Find(dep.Name)
+		version = p.Version
+	}
+	if version == "" {
+		return validationError("%s requires %s %s, but the %s version of %s is unknown", packageName, dep.Name, dep.Version, state, dep.Name)
+	}
+
+	ok, err := versionSatisfies(version, dep.Version)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return validationError("%s requires %s %s, but %s %s is %s", packageName, dep.Name, dep.Version, dep.Name, version, state)
+	}
+	return nil
+}
+
+// dependents returns the installed releases that depend on packageName
+func dependents(packageName string, releases []Release) []Release {
+	var result []Release
+	for _, release := range releases {
+		for _, dep := range release.DependsOn {
+			if dep.Name == packageName && release.Package != packageName {
+				result = append(result, release)
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
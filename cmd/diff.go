@@ -0,0 +1,505 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rancher/k3p/pkg/registry"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	diffContext = 3
+
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+	colorBold  = "\x1b[1m"
+	colorReset = "\x1b[0m"
+)
+
+var (
+	diffNamespace string
+	diffProfile   string
+	diffNoColor   bool
+)
+
+// DiffSummary lists the objects an upgrade would add, change or remove
+type DiffSummary struct {
+	Added     []resourceRef `json:"added"`
+	Changed   []resourceRef `json:"changed"`
+	Removed   []resourceRef `json:"removed"`
+	Unchanged int           `json:"unchanged"`
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what upgrading a package release to the package version in the local cache would change",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		live, updated, err := releaseManifests(args[0], diffNamespace, diffProfile)
+		if err != nil {
+			return err
+		}
+		summary, diffs, err := diffObjects(live, updated)
+		if err != nil {
+			return err
+		}
+
+		cmdResult.Data = summary
+		return printResult(func(w io.Writer) {
+			color := !diffNoColor && isTerminal(os.Stdout)
+			printDiff(w, summary, diffs, color)
+		})
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffNamespace, "namespace", "n", "", "namespace of the release")
+	diffCmd.Flags().StringVarP(&diffProfile, "profile", "p", "", "profile to render the package with, defaults to the profile of the release")
+	diffCmd.Flags().BoolVarP(&diffNoColor, "no-color", "", false, "don't color the diff")
+}
+
+// releaseManifests returns the manifests and CRDs of the installed release and the ones an upgrade would apply.
+// name is a release installed by k3p or a package name.
+func releaseManifests(name, namespace, profile string) ([]byte, []byte, error) {
+	release, err := findRelease(name, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if release == nil {
+		packageYaml, err := loadPackageYaml(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ns, err := releaseNamespace(namespace, packageYaml)
+		if err != nil {
+			return nil, nil, err
+		}
+		release = &Release{
+			Name:      name,
+			Namespace: ns,
+			Package:   name,
+		}
+	}
+	if profile == "" {
+		profile = release.Profile
+	}
+
+	packageYaml, err := loadPackageYaml(release.Package)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updated, err := renderRelease(release.Name, release.Namespace, release.Package, packageYaml, profile, release.Values, release.Answers)
+	if err != nil {
+		return nil, nil, err
+	}
+	registryConf, err := registryConfig(packageYaml.PrivateRegistry, release.PrivateRegistry, release.RegistryMirrors, release.ImagePullSecrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if registryConf.Enabled() {
+		if updated, err = registry.RewriteManifests(updated, registryConf); err != nil {
+			return nil, nil, err
+		}
+	}
+	updated = joinManifests(updated, []byte(packageYaml.CRDManifest))
+
+	var live []byte
+	exists, err := helmReleaseExists(release.Name, release.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exists {
+		if live, err = helm(namespaceArgs(release.Namespace, "get", "manifest", release.Name)...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	liveCRDs, err := installedCRDs(*release, packageYaml.CRDManifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return joinManifests(live, liveCRDs), updated, nil
+}
+
+// installedCRDs returns the CRD manifest the release was installed with. Releases that didn't record it are
+// compared with the CRDs of the package as they exist in the cluster.
+func installedCRDs(release Release, crdManifest string) ([]byte, error) {
+	if release.CRDDigest != "" {
+		manifest, err := loadCRDManifest(release.Name, release.Namespace, release.CRDDigest)
+		return []byte(manifest), err
+	}
+
+	kinds, err := crdKinds(crdManifest)
+	if err != nil {
+		return nil, err
+	}
+	var crds []map[string]interface{}
+	for _, kind := range kinds {
+		output, err := kubectl(nil, "get", "customresourcedefinition", kind.Name, "--ignore-not-found", "-o", "json")
+		if err != nil {
+			return nil, err
+		}
+		if len(output) == 0 {
+			continue
+		}
+		crd := map[string]interface{}{}
+		if err := json.Unmarshal(output, &crd); err != nil {
+			return nil, err
+		}
+		crds = append(crds, crd)
+	}
+	return objectsYaml(crds)
+}
+
+func joinManifests(manifests ...[]byte) []byte {
+	var docs []string
+	for _, m := range manifests {
+		if len(strings.TrimSpace(string(m))) > 0 {
+			docs = append(docs, string(m))
+		}
+	}
+	return []byte(strings.Join(docs, "\n---\n"))
+}
+
+// manifestObjects returns the objects of a manifest as normalized YAML by kind, namespace and name
+func manifestObjects(manifest []byte) (map[resourceRef]string, error) {
+	result := map[resourceRef]string{}
+	for _, doc := range registry.SplitDocuments(manifest) {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		ref := resourceRef{
+			Kind:      nestedString(obj, "kind"),
+			Namespace: nestedString(obj, "metadata", "namespace"),
+			Name:      nestedString(obj, "metadata", "name"),
+		}
+		if ref.Kind == "" || ref.Name == "" {
+			continue
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		result[ref] = string(data)
+	}
+	return result, nil
+}
+
+// diffObjects compares the objects of two manifests and returns a unified diff of every object that differs
+func diffObjects(live, updated []byte) (DiffSummary, map[resourceRef][]string, error) {
+	summary := DiffSummary{}
+	diffs := map[resourceRef][]string{}
+
+	liveObjects, err := manifestObjects(live)
+	if err != nil {
+		return summary, nil, err
+	}
+	updatedObjects, err := manifestObjects(updated)
+	if err != nil {
+		return summary, nil, err
+	}
+
+	for ref, obj := range updatedObjects {
+		old, ok := liveObjects[ref]
+		switch {
+		case !ok:
+			summary.Added = append(summary.Added, ref)
+		case old != obj:
+			summary.Changed = append(summary.Changed, ref)
+		default:
+			summary.Unchanged++
+			continue
+		}
+		diffs[ref] = unifiedDiff(splitLines(old), splitLines(obj), "installed "+ref.String(), "upgrade "+ref.String())
+	}
+	for ref, obj := range liveObjects {
+		if _, ok := updatedObjects[ref]; !ok {
+			summary.Removed = append(summary.Removed, ref)
+			diffs[ref] = unifiedDiff(splitLines(obj), nil, "installed "+ref.String(), "upgrade "+ref.String())
+		}
+	}
+
+	sortRefs(summary.Added)
+	sortRefs(summary.Changed)
+	sortRefs(summary.Removed)
+	return summary, diffs, nil
+}
+
+func sortRefs(refs []resourceRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		if refs[i].Namespace != refs[j].Namespace {
+			return refs[i].Namespace < refs[j].Namespace
+		}
+		return refs[i].Name < refs[j].Name
+	})
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func printDiff(w io.Writer, summary DiffSummary, diffs map[resourceRef][]string, color bool) {
+	var refs []resourceRef
+	for ref := range diffs {
+		refs = append(refs, ref)
+	}
+	sortRefs(refs)
+
+	kind := ""
+	for _, ref := range refs {
+		if ref.Kind != kind {
+			kind = ref.Kind
+			fmt.Fprintln(w, colorize(fmt.Sprintf("== %s ==", kind), colorBold, color))
+		}
+		for _, line := range diffs[ref] {
+			switch {
+			case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+				fmt.Fprintln(w, colorize(line, colorBold, color))
+			case strings.HasPrefix(line, "@@"):
+				fmt.Fprintln(w, colorize(line, colorCyan, color))
+			case strings.HasPrefix(line, "+"):
+				fmt.Fprintln(w, colorize(line, colorGreen, color))
+			case strings.HasPrefix(line, "-"):
+				fmt.Fprintln(w, colorize(line, colorRed, color))
+			default:
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%d added, %d changed, %d removed, %d unchanged\n",
+		len(summary.Added), len(summary.Changed), len(summary.Removed), summary.Unchanged)
+}
+
+func colorize(s, color string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return color + s + colorReset
+}
+
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// diffLines returns the lines of two texts that are kept, removed or added. It uses the linear space variant of Myers'
+// algorithm, so rendered manifests and CRDs of any size can be compared. Removed lines come before the added lines
+// replacing them.
+func diffLines(a, b []string) []diffLine {
+	var result []diffLine
+	diffRange(a, b, &result)
+
+	for start := 0; start < len(result); start++ {
+		if result[start].Op == ' ' {
+			continue
+		}
+		end := start
+		for end < len(result) && result[end].Op != ' ' {
+			end++
+		}
+		changes := result[start:end]
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Op == '-' && changes[j].Op == '+'
+		})
+		start = end
+	}
+	return result
+}
+
+// diffRange appends the diff of a and b to result, splitting it at the middle snake of the shortest edit script
+func diffRange(a, b []string, result *[]diffLine) {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		*result = append(*result, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	common := 0
+	for common < len(a) && common < len(b) && a[len(a)-1-common] == b[len(b)-1-common] {
+		common++
+	}
+	suffix := a[len(a)-common:]
+	a, b = a[:len(a)-common], b[:len(b)-common]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			*result = append(*result, diffLine{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			*result = append(*result, diffLine{'-', line})
+		}
+	default:
+		if x, y, ok := middleSnake(a, b); ok {
+			diffRange(a[:x], b[:y], result)
+			diffRange(a[x:], b[y:], result)
+		} else {
+			for _, line := range a {
+				*result = append(*result, diffLine{'-', line})
+			}
+			for _, line := range b {
+				*result = append(*result, diffLine{'+', line})
+			}
+		}
+	}
+
+	for _, line := range suffix {
+		*result = append(*result, diffLine{' ', line})
+	}
+}
+
+// middleSnake searches the shortest edit script of two texts from both ends at once and returns where the paths
+// meet. a and b differ in their first and last lines. It returns false if they have no line in common.
+func middleSnake(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward[offset+k] is the furthest x reached on diagonal k from the start, backward the same from the end
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// with an odd delta the paths meet while extending the forward path, otherwise the backward one
+	odd := delta%2 != 0
+	kStart, kEnd, kBackStart, kBackEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + kStart; k <= d-kEnd; k += 2 {
+			i := offset + k
+			x := 0
+			if k == -d || (k != d && forward[i-1] < forward[i+1]) {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[i] = x
+			switch {
+			case x > n:
+				kEnd += 2
+			case y > m:
+				kStart += 2
+			case odd:
+				if j := offset + delta - k; j >= 0 && j < len(backward) && backward[j] != -1 && x >= n-backward[j] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + kBackStart; k <= d-kBackEnd; k += 2 {
+			i := offset + k
+			x := 0
+			if k == -d || (k != d && backward[i-1] < backward[i+1]) {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+			switch {
+			case x > n:
+				kBackEnd += 2
+			case y > m:
+				kBackStart += 2
+			case !odd:
+				if j := offset + delta - k; j >= 0 && j < len(forward) && forward[j] != -1 {
+					forwardX := forward[j]
+					if forwardX >= n-x {
+						return forwardX, forwardX - (j - offset), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// unifiedDiff returns the differences of two texts in unified diff format
+func unifiedDiff(a, b []string, fromName, toName string) []string {
+	lines := diffLines(a, b)
+
+	// aPos and bPos are the line numbers in a and b before each diff line
+	aPos := make([]int, len(lines)+1)
+	bPos := make([]int, len(lines)+1)
+	for i, l := range lines {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if l.Op != '+' {
+			aPos[i+1]++
+		}
+		if l.Op != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	result := []string{"--- " + fromName, "+++ " + toName}
+	for start := 0; start < len(lines); {
+		for start < len(lines) && lines[start].Op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		last := start
+		for k := start; k < len(lines); k++ {
+			if lines[k].Op == ' ' {
+				continue
+			}
+			if k-last > 2*diffContext {
+				break
+			}
+			last = k
+		}
+
+		hunkStart := start - diffContext
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := last + diffContext + 1
+		if hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+
+		result = append(result, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(aPos[hunkStart], aPos[hunkEnd]-aPos[hunkStart]),
+			hunkRange(bPos[hunkStart], bPos[hunkEnd]-bPos[hunkStart])))
+		for _, l := range lines[hunkStart:hunkEnd] {
+			result = append(result, string(l.Op)+l.Text)
+		}
+		start = hunkEnd
+	}
+	return result
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
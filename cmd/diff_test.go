@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected []string
+	}{
+		{
+			name: "changed line",
+			a:    "a\nb\nc\n",
+			b:    "a\nx\nc\n",
+			expected: []string{
+				"--- old", "+++ new",
+				"@@ -1,3 +1,3 @@", " a", "-b", "+x", " c",
+			},
+		},
+		{
+			name:     "added object",
+			a:        "",
+			b:        "a\nb\n",
+			expected: []string{"--- old", "+++ new", "@@ -0,0 +1,2 @@", "+a", "+b"},
+		},
+		{
+			name:     "removed object",
+			a:        "a\nb\n",
+			b:        "",
+			expected: []string{"--- old", "+++ new", "@@ -1,2 +0,0 @@", "-a", "-b"},
+		},
+		{
+			name:     "unchanged",
+			a:        "a\nb\n",
+			b:        "a\nb\n",
+			expected: []string{"--- old", "+++ new"},
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			expected: []string{
+				"--- old", "+++ new",
+				"@@ -1,4 +1,4 @@", "-1", "+x", " 2", " 3", " 4",
+				"@@ -9,4 +9,4 @@", " 9", " 10", " 11", "-12", "+y",
+			},
+		},
+	}
+	for _, test := range tests {
+		actual := unifiedDiff(splitLines(test.a), splitLines(test.b), "old", "new")
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, strings.Join(test.expected, "\n"), strings.Join(actual, "\n"))
+		}
+	}
+}
+
+// checkDiff fails if a diff doesn't turn a into b or isn't as short as the longest common subsequence allows
+func checkDiff(t *testing.T, a, b []string, lines []diffLine, lcs int) {
+	var from, to []string
+	kept := 0
+	for _, l := range lines {
+		switch l.Op {
+		case ' ':
+			from, to = append(from, l.Text), append(to, l.Text)
+			kept++
+		case '-':
+			from = append(from, l.Text)
+		case '+':
+			to = append(to, l.Text)
+		}
+	}
+	if !equalStrings(from, a) || !equalStrings(to, b) {
+		t.Fatalf("diff of %v and %v doesn't reproduce them: %v", a, b, lines)
+	}
+	if lcs >= 0 && kept != lcs {
+		t.Fatalf("diff of %v and %v keeps %d lines, expected %d: %v", a, b, kept, lcs, lines)
+	}
+}
+
+func longestCommonSubsequence(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+func TestDiffLinesMinimal(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	text := func() []string {
+		var result []string
+		for i := random.Intn(20); i > 0; i-- {
+			result = append(result, string('a'+rune(random.Intn(4))))
+		}
+		return result
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := text(), text()
+		checkDiff(t, a, b, diffLines(a, b), longestCommonSubsequence(a, b))
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20000; i++ {
+		a = append(a, fmt.Sprintf("line %d", i))
+		if i%100 == 0 {
+			b = append(b, fmt.Sprintf("changed %d", i))
+		} else if i%333 != 0 {
+			b = append(b, fmt.Sprintf("line %d", i))
+		}
+	}
+	start := time.Now()
+	lines := diffLines(a, b)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("diffing 20000 lines took %v", elapsed)
+	}
+	checkDiff(t, a, b, lines, -1)
+}
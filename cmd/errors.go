@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// exit codes of failing commands
+const (
+	exitFailure    = 1
+	exitUsage      = 2
+	exitNotFound   = 3
+	exitNetwork    = 4
+	exitCluster    = 5
+	exitValidation = 6
+)
+
+// codedError is an error that sets the exit code of k3p
+type codedError struct {
+	error
+	code int
+}
+
+// Cause lets errors.Cause and exitCode look through the exit code
+func (e *codedError) Cause() error {
+	return e.error
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{error: err, code: code}
+}
+
+// usageError is returned for invalid arguments and flags
+func usageError(format string, args ...interface{}) error {
+	return withExitCode(exitUsage, fmt.Errorf(format, args...))
+}
+
+// notFoundError is returned if a package or release doesn't exist
+func notFoundError(format string, args ...interface{}) error {
+	return withExitCode(exitNotFound, fmt.Errorf(format, args...))
+}
+
+// validationError is returned if a package, file or change is rejected
+func validationError(format string, args ...interface{}) error {
+	return withExitCode(exitValidation, fmt.Errorf(format, args...))
+}
+
+// networkError marks a failure to fetch a package, chart or patch
+func networkError(err error) error {
+	return withExitCode(exitNetwork, err)
+}
+
+// clusterError marks a failure of helm or kubectl talking to the cluster
+func clusterError(err error) error {
+	return withExitCode(exitCluster, err)
+}
+
+// exitCode returns the exit code of the outermost coded error wrapped by err
+func exitCode(err error) int {
+	for err != nil {
+		if coded, ok := err.(*codedError); ok {
+			return coded.code
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return exitFailure
+}
+
+// usageArgs marks the errors of a cobra argument validator as usage errors
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return withExitCode(exitUsage, errors.Wrap(err, cmd.CommandPath()))
+		}
+		return nil
+	}
+}
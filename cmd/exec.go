@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execCommand runs an external command and returns its stdout. stderr is included in the returned error.
+func execCommand(stdin []byte, name string, args ...string) ([]byte, error) {
+	c := exec.Command(name, args...)
+	if stdin != nil {
+		c.Stdin = bytes.NewReader(stdin)
+	}
+	stderr := &bytes.Buffer{}
+	c.Stderr = stderr
+
+	start := time.Now()
+	output, err := c.Output()
+	logCommand(name, args, start, err)
+	if err != nil {
+		return output, fmt.Errorf("%s failed: %v: %s", commandLine(name, args), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// maxObjectSize is the largest ConfigMap or Secret the API server accepts
+const maxObjectSize = 1024 * 1024
+
+// saveObject creates an object, or replaces it if it exists. Unlike client-side `kubectl apply` it doesn't copy the
+// object into its last-applied-configuration annotation, which is limited to 256 KiB.
+func saveObject(manifest []byte) error {
+	if len(manifest) > maxObjectSize {
+		return validationError("object of %d bytes is larger than the %d bytes the API server accepts", len(manifest), maxObjectSize)
+	}
+	_, err := kubectl(manifest, "create", "-f", "-")
+	if err != nil && strings.Contains(err.Error(), "AlreadyExists") {
+		_, err = kubectl(manifest, "replace", "-f", "-")
+	}
+	return err
+}
+
+func kubectl(stdin []byte, args ...string) ([]byte, error) {
+	output, err := execCommand(stdin, "kubectl", args...)
+	return output, clusterError(err)
+}
+
+func helm(args ...string) ([]byte, error) {
+	output, err := execCommand(nil, "helm", args...)
+	return output, clusterError(err)
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const maxRetryBackoff = 30 * time.Second
+
+var (
+	// httpClients are the clients of the repositories by name, the client without a repository is ""
+	httpClients = map[string]*http.Client{}
+)
+
+// httpGet fetches a URL, retrying network errors and server errors with exponential backoff. Requests for a URL of the
+// named repository use its TLS settings and credentials.
+func httpGet(repoName, rawURL string) ([]byte, error) {
+	if strings.HasPrefix(rawURL, "file://") {
+		return ioutil.ReadFile(strings.TrimPrefix(rawURL, "file://"))
+	}
+
+	if rewritten := config.rewriteURL(rawURL); rewritten != rawURL {
+		logrus.Debugf("Rewrote %s to %s", rawURL, rewritten)
+		rawURL = rewritten
+	}
+
+	repo := repositoryFor(repoName, rawURL)
+	client, err := httpClient(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := config.HTTP.retries()
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		data, retry, err := fetch(client, repo, rawURL)
+		if err == nil {
+			return data, nil
+		}
+		if !retry || attempt >= retries {
+			return nil, networkError(err)
+		}
+		logrus.Warnf("Fetching %s failed, retrying in %v (%d/%d): %v", rawURL, backoff, attempt+1, retries, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// httpGetMirrored fetches the first URL of a list of alternates that can be fetched and matches the digest, if there
+// is one. Digests are sha256:<hex>, as in lockfiles. The mirrors of the named repository are tried last.
+func httpGetMirrored(repoName string, urls []string, digest string) ([]byte, error) {
+	urls = withRepositoryMirrors(repoName, urls)
+	if digest != "" && !strings.HasPrefix(digest, "sha256:") {
+		return nil, validationError("unsupported digest %s of %s, only sha256 is supported", digest, urls[0])
+	}
+
+	var lastErr error
+	for i, u := range urls {
+		data, err := httpGet(repoName, u)
+		if err == nil && digest != "" {
+			if actual := "sha256:" + sha256Hex(data); actual != digest {
+				err = validationError("digest of %s is %s, expected %s", u, actual, digest)
+			}
+		}
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+		if i < len(urls)-1 {
+			logrus.Warnf("Fetching %s failed, trying %s: %v", u, urls[i+1], err)
+		}
+	}
+	if len(urls) > 1 {
+		return nil, errors.Wrapf(lastErr, "%s and its %d mirrors failed", urls[0], len(urls)-1)
+	}
+	return nil, lastErr
+}
+
+// withRepositoryMirrors adds the URLs of the mirrors of a repository to a list of alternates. A URL below the directory
+// of the index is expected below the directory of the index on each mirror.
+func withRepositoryMirrors(repoName string, urls []string) []string {
+	result := append([]string{}, urls...)
+	seen := map[string]bool{}
+	for _, u := range urls {
+		seen[u] = true
+	}
+	for _, r := range config.Repositories {
+		if r.Name != repoName {
+			continue
+		}
+		dir := urlDir(r.URL)
+		for _, u := range urls {
+			if !strings.HasPrefix(u, dir) {
+				continue
+			}
+			for _, m := range r.Mirrors {
+				if mirrored := urlDir(m) + strings.TrimPrefix(u, dir); !seen[mirrored] {
+					seen[mirrored] = true
+					result = append(result, mirrored)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// urlDir returns a URL up to the last slash of its path
+func urlDir(rawURL string) string {
+	start := strings.Index(rawURL, "://") + len("://")
+	if i := strings.LastIndex(rawURL, "/"); i >= start {
+		return rawURL[:i+1]
+	}
+	return rawURL + "/"
+}
+
+// fetch does a single GET request. It returns whether a failed request may succeed when it is retried.
+func fetch(client *http.Client, repo *Repository, rawURL string) ([]byte, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("User-Agent", "k3p")
+	if repo != nil {
+		if token := os.ExpandEnv(repo.Token); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if repo.Username != "" {
+			req.SetBasicAuth(os.ExpandEnv(repo.Username), os.ExpandEnv(repo.Password))
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.WithField("duration", time.Since(start).Round(time.Millisecond).String()).WithError(err).Debugf("GET %s", rawURL)
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	logrus.WithField("duration", time.Since(start).Round(time.Millisecond).String()).Debugf("GET %s %s", rawURL, resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retry, fmt.Errorf("GET %s failed: %s", rawURL, resp.Status)
+	}
+	if err != nil {
+		return nil, true, errors.Wrapf(err, "reading %s", rawURL)
+	}
+	return data, false, nil
+}
+
+// repositoryFor returns the named repository if a URL is below the directory of its index or a mirror. If several
+// repositories have the name, e.g. none was given, the one with the longest matching directory is returned.
+func repositoryFor(repoName, rawURL string) *Repository {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	var (
+		result  *Repository
+		longest = -1
+	)
+	for i, r := range config.Repositories {
+		if repoName != "" && r.Name != repoName {
+			continue
+		}
+		for _, candidate := range append([]string{r.URL}, r.Mirrors...) {
+			repoURL, err := url.Parse(config.rewriteURL(candidate))
+			if err != nil || repoURL.Scheme != u.Scheme || repoURL.Host != u.Host {
+				continue
+			}
+			dir := repoURL.Path[:strings.LastIndex(repoURL.Path, "/")+1]
+			if strings.HasPrefix(u.Path, dir) && len(dir) > longest {
+				result, longest = &config.Repositories[i], len(dir)
+			}
+		}
+	}
+	return result
+}
+
+// httpClient returns the client for a repository, with the timeouts and CA bundle of the config. Proxies are taken
+// from HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func httpClient(repo *Repository) (*http.Client, error) {
+	name := ""
+	if repo != nil {
+		name = repo.Name
+	}
+	if client, ok := httpClients[name]; ok {
+		return client, nil
+	}
+
+	tlsConfig, err := tlsConfig(repo)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   config.HTTP.connectTimeout(),
+		KeepAlive: 30 * time.Second,
+	}
+	client := &http.Client{
+		Timeout: config.HTTP.timeout(),
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   config.HTTP.connectTimeout(),
+			ResponseHeaderTimeout: config.HTTP.timeout(),
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+	httpClients[name] = client
+	return client, nil
+}
+
+func tlsConfig(repo *Repository) (*tls.Config, error) {
+	result := &tls.Config{}
+	caFiles := []string{config.HTTP.CAFile}
+	if repo != nil {
+		caFiles = append(caFiles, repo.CAFile)
+		result.InsecureSkipVerify = repo.InsecureSkipTLSVerify
+		if repo.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(repo.CertFile, repo.KeyFile)
+			if err != nil {
+				return nil, withExitCode(exitValidation, errors.Wrapf(err, "failed to load client certificate of repository %s", repo.Name))
+			}
+			result.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	for _, file := range caFiles {
+		if file == "" {
+			continue
+		}
+		if result.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			result.RootCAs = pool
+		}
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !result.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, validationError("no certificates found in CA bundle %s", file)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import "testing"
+
+func TestRepositoryFor(t *testing.T) {
+	defer func(old *Config) { config = old }(config)
+	config = &Config{Repositories: []Repository{
+		{Name: "a", URL: "https://storage.googleapis.com/bucket-a/index.yaml"},
+		{Name: "b", URL: "https://storage.googleapis.com/bucket-b/index.yaml", Mirrors: []string{"https://mirror.example.com/b/index.yaml"}},
+		{Name: "nested", URL: "https://storage.googleapis.com/bucket-a/nested/index.yaml"},
+	}}
+
+	tests := []struct {
+		repo, url, expected string
+	}{
+		{"", "https://storage.googleapis.com/bucket-a/foo/package.yaml", "a"},
+		{"", "https://storage.googleapis.com/bucket-b/foo/package.yaml", "b"},
+		{"", "https://storage.googleapis.com/bucket-a/nested/foo.tgz", "nested"},
+		{"", "https://mirror.example.com/b/foo.tgz", "b"},
+		{"", "https://storage.googleapis.com/bucket-c/foo.tgz", ""},
+		{"", "http://storage.googleapis.com/bucket-a/foo.tgz", ""},
+		{"a", "https://storage.googleapis.com/bucket-b/foo.tgz", ""},
+		{"a", "https://storage.googleapis.com/bucket-a/nested/foo.tgz", "a"},
+		{"b", "https://mirror.example.com/b/foo.tgz", "b"},
+	}
+	for _, test := range tests {
+		name := ""
+		if repo := repositoryFor(test.repo, test.url); repo != nil {
+			name = repo.Name
+		}
+		if name != test.expected {
+			t.Errorf("repository of %s listed by %q: expected %q, got %q", test.url, test.repo, test.expected, name)
+		}
+	}
+}
+
+func TestWithRepositoryMirrors(t *testing.T) {
+	defer func(old *Config) { config = old }(config)
+	config = &Config{Repositories: []Repository{
+		{Name: "a", URL: "https://storage.googleapis.com/bucket-a/index.yaml", Mirrors: []string{"https://mirror.example.com/a/index.yaml", "https://other.example.com"}},
+		{Name: "b", URL: "https://storage.googleapis.com/bucket-b/index.yaml"},
+	}}
+
+	tests := []struct {
+		repo     string
+		urls     []string
+		expected []string
+	}{
+		{"a", []string{"https://storage.googleapis.com/bucket-a/index.yaml", "https://mirror.example.com/a/index.yaml"}, []string{
+			"https://storage.googleapis.com/bucket-a/index.yaml",
+			"https://mirror.example.com/a/index.yaml",
+			"https://other.example.com/index.yaml",
+		}},
+		{"a", []string{"https://storage.googleapis.com/bucket-a/foo/base.tgz", "https://alt.example.com/foo/base.tgz"}, []string{
+			"https://storage.googleapis.com/bucket-a/foo/base.tgz",
+			"https://alt.example.com/foo/base.tgz",
+			"https://mirror.example.com/a/foo/base.tgz",
+			"https://other.example.com/foo/base.tgz",
+		}},
+		{"a", []string{"https://storage.googleapis.com/bucket-b/foo/base.tgz"}, []string{
+			"https://storage.googleapis.com/bucket-b/foo/base.tgz",
+		}},
+		{"b", []string{"https://storage.googleapis.com/bucket-b/foo/base.tgz"}, []string{
+			"https://storage.googleapis.com/bucket-b/foo/base.tgz",
+		}},
+	}
+	for _, test := range tests {
+		if actual := withRepositoryMirrors(test.repo, test.urls); !equalStrings(actual, test.expected) {
+			t.Errorf("mirrors of %v in repository %s: expected %v, got %v", test.urls, test.repo, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3p/pkg/rbac"
+	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	hookLabel = "k3p.io/hook"
+)
+
+var (
+	invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+)
+
+// hookJobName returns a valid object name for the job of a hook, leaving room for the rbac name suffixes
+func hookJobName(c hookContext, hookName string) string {
+	name := strings.ToLower(fmt.Sprintf("%s-%s-%s", c.Release, c.Phase, hookName))
+	name = invalidNameChars.ReplaceAllString(name, "-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return strings.Trim(name, "-")
+}
+
+func currentNamespace() (string, error) {
+	output, err := kubectl(nil, "config", "view", "--minify", "-o", "jsonpath={..namespace}")
+	if err != nil {
+		return "", err
+	}
+	if ns := strings.TrimSpace(string(output)); ns != "" {
+		return ns, nil
+	}
+	return "default", nil
+}
+
+// hookJobObjects returns the service account, rbac and Job that run a hook in the cluster
+func hookJobObjects(name, namespace string, hook Hook, c hookContext, timeout time.Duration) []runtime.Object {
+	objects := []runtime.Object{rbac.ServiceAccount(name, namespace)}
+	if hook.Rbac != nil && len(hook.Rbac.Rules) > 0 {
+		objects = append(objects,
+			rbac.Role(name, namespace, rbacv1.Role{Rules: hook.Rbac.Rules}),
+			rbac.RoleBinding(name, namespace))
+	}
+	if hook.Rbac != nil && len(hook.Rbac.ClusterRules) > 0 {
+		objects = append(objects,
+			rbac.ClusterRole(name, namespace, rbacv1.ClusterRole{Rules: hook.Rbac.ClusterRules}),
+			rbac.ClusterRoleBinding(name, namespace))
+	}
+
+	var env []v1.EnvVar
+	for _, e := range c.env() {
+		parts := strings.SplitN(e, "=", 2)
+		if parts[0] == "KUBECONFIG" {
+			continue
+		}
+		env = append(env, v1.EnvVar{Name: parts[0], Value: parts[1]})
+	}
+	for k, v := range hook.Env {
+		env = append(env, v1.EnvVar{Name: k, Value: v})
+	}
+
+	command := hook.Command
+	if hook.Script != "" {
+		command = []string{"sh", "-c", hook.Script}
+	}
+
+	backoffLimit := int32(hook.Retries)
+	deadline := int64(timeout.Seconds())
+	objects = append(objects, &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				hookLabel:    name,
+				releaseLabel: c.Release,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						hookLabel: name,
+					},
+				},
+				Spec: v1.PodSpec{
+					ServiceAccountName: rbac.ServiceAccountName(name),
+					RestartPolicy:      v1.RestartPolicyNever,
+					Containers: []v1.Container{
+						{
+							Name:    "hook",
+							Image:   hook.Image,
+							Command: command,
+							Env:     env,
+						},
+					},
+				},
+			},
+		},
+	})
+	return objects
+}
+
+// runJobHook runs a hook as a Job in the target namespace, streams its logs and removes it once it is done
+func runJobHook(hookName string, hook Hook, c hookContext, timeout time.Duration) error {
+	namespace := c.Namespace
+	if namespace == "" {
+		ns, err := currentNamespace()
+		if err != nil {
+			return err
+		}
+		namespace = ns
+	}
+	name := hookJobName(c, hookName)
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      hookJobObjects(name, namespace, hook, c, timeout),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := deleteHookJob(name, namespace, timeout); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := kubectl(manifest, "delete", "-f", "-", "--ignore-not-found"); err != nil {
+			logrus.Warnf("Failed to clean up hook %s: %v", hookName, err)
+		}
+	}()
+
+	logrus.Infof("Running %s hook %s as job %s/%s", c.Phase, hookName, namespace, name)
+	if _, err := kubectl(manifest, "apply", "-f", "-"); err != nil {
+		return err
+	}
+
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		logs := exec.Command("kubectl", "logs", "-f", "job/"+name, "--namespace", namespace,
+			fmt.Sprintf("--pod-running-timeout=%v", timeout))
+		logs.Stdout = os.Stderr
+		logs.Stderr = os.Stderr
+		start := time.Now()
+		err := logs.Run()
+		logCommand("kubectl", logs.Args[1:], start, err)
+	}()
+
+	err = waitForJob(name, namespace, timeout)
+	select {
+	case <-logsDone:
+	case <-time.After(5 * time.Second):
+	}
+	return err
+}
+
+// deleteHookJob deletes the Job of an earlier run of a hook and waits until it and its pods are gone, so the new Job
+// isn't applied to a terminating one and its logs aren't read from an old pod
+func deleteHookJob(name, namespace string, timeout time.Duration) error {
+	if _, err := kubectl(nil, "delete", "job", name, "--namespace", namespace, "--ignore-not-found"); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		output, err := kubectl(nil, "get", "job,pod", "-l", hookLabel+"="+name, "--namespace", namespace, "-o", "name")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(output)) == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return clusterError(fmt.Errorf("timed out after %v waiting for the old job %s to be deleted", timeout, name))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func waitForJob(name, namespace string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		output, err := kubectl(nil, "get", "job", name, "--namespace", namespace, "-o", "json")
+		if err != nil {
+			return err
+		}
+		job := &batchv1.Job{}
+		if err := json.Unmarshal(output, job); err != nil {
+			return err
+		}
+
+		if job.Status.Succeeded > 0 {
+			return nil
+		}
+		for _, cond := range job.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Status == v1.ConditionTrue {
+				return clusterError(fmt.Errorf("job %s failed: %s %s", name, cond.Reason, cond.Message))
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return clusterError(fmt.Errorf("timed out after %v waiting for job %s", timeout, name))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+	// hookKillGrace is how long to wait for the output of a hook after it was killed on timeout
+	hookKillGrace = 5 * time.Second
+)
+
+// hookContext describes the release a hook runs for. It is passed to hooks as environment variables.
+type hookContext struct {
+	Phase     string
+	Package   string
+	Version   string
+	Release   string
+	Namespace string
+}
+
+func (c hookContext) env() []string {
+	kubeconfig := os.Getenv("KUBECONFIG")
+	if kubeconfig == "" {
+		kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	}
+	return []string{
+		"K3P_HOOK=" + c.Phase,
+		"K3P_PACKAGE=" + c.Package,
+		"K3P_PACKAGE_VERSION=" + c.Version,
+		"K3P_RELEASE=" + c.Release,
+		"K3P_NAMESPACE=" + c.Namespace,
+		"KUBECONFIG=" + kubeconfig,
+	}
+}
+
+// preDeleteHooks returns the preDelete hooks of a package, including the legacy preDeleteCommand entries
+func preDeleteHooks(packageYaml *PackageYaml) []Hook {
+	var hooks []Hook
+	for _, command := range packageYaml.PreDeleteCommand {
+		if strings.TrimSpace(command) == "" {
+			continue
+		}
+		hooks = append(hooks, Hook{
+			Name:   command,
+			Script: command,
+		})
+	}
+	return append(hooks, packageYaml.Hooks.PreDelete...)
+}
+
+// runHooks runs the hooks of a phase in order and stops at the first hook that fails without continueOnError
+func runHooks(hooks []Hook, c hookContext) error {
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("%s[%d]", c.Phase, i)
+		}
+
+		err := runHook(name, hook, c)
+		if err == nil {
+			continue
+		}
+		if hook.ContinueOnError {
+			logrus.Warnf("Hook %s failed, continuing: %v", name, err)
+			continue
+		}
+		return errors.Wrapf(err, "hook %s failed", name)
+	}
+	return nil
+}
+
+func runHook(name string, hook Hook, c hookContext) error {
+	timeout := defaultHookTimeout
+	if hook.Timeout != "" {
+		t, err := time.ParseDuration(hook.Timeout)
+		if err != nil {
+			return validationError("invalid timeout %q: %v", hook.Timeout, err)
+		}
+		timeout = t
+	}
+
+	if hook.Image != "" {
+		// the Job retries failed pods itself, up to hook.Retries times
+		return runJobHook(name, hook, c, timeout)
+	}
+
+	var err error
+	for attempt := 0; attempt <= hook.Retries; attempt++ {
+		if attempt > 0 {
+			logrus.Infof("Retrying hook %s (%d/%d)", name, attempt, hook.Retries)
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+		if err = execHook(name, hook, c, timeout); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func execHook(name string, hook Hook, c hookContext, timeout time.Duration) error {
+	var args []string
+	switch {
+	case len(hook.Command) > 0 && hook.Script != "":
+		return validationError("only one of command and script can be set")
+	case len(hook.Command) > 0:
+		args = hook.Command
+	case hook.Script != "":
+		args = []string{"sh", "-c", hook.Script}
+	default:
+		return validationError("either command or script is required")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	// the hook runs in its own process group, so processes it started are killed with it on timeout
+	setProcessGroup(cmd)
+	cmd.Env = append(os.Environ(), c.env()...)
+	for k, v := range hook.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	logrus.Infof("Running %s hook %s", c.Phase, name)
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		logCommand(args[0], args[1:], start, err)
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		killProcessGroup(cmd)
+		err = fmt.Errorf("timed out after %v", timeout)
+		select {
+		case <-done:
+		case <-time.After(hookKillGrace):
+			// a process outside of the group still holds the output, the buffer is still written to
+			logCommand(args[0], args[1:], start, err)
+			return errors.Wrap(err, "hook output is still held open by a process it started")
+		}
+	}
+	logCommand(args[0], args[1:], start, err)
+	if err != nil {
+		logrus.Warn(strings.TrimSpace(output.String()))
+		return err
+	}
+	return nil
+}
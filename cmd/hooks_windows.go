@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts a command in a process group of its own
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killProcessGroup kills a command started with setProcessGroup and every process it started
+func killProcessGroup(cmd *exec.Cmd) {
+	if err := exec.Command("taskkill", "/F", "/T", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		cmd.Process.Kill()
+	}
+}
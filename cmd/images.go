@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rancher/k3p/pkg/registry"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imagesProfile string
+)
+
+type packageImage struct {
+	Image     string   `json:"image"`
+	Workloads []string `json:"workloads"`
+}
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "List the images a package will pull",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		packageName := args[0]
+
+		packageYaml, err := loadPackageYaml(packageName)
+		if err != nil {
+			return err
+		}
+
+		images, err := packageImages(packageName, packageYaml, imagesProfile)
+		if err != nil {
+			return err
+		}
+
+		cmdResult.Data = images
+		return printResult(func(w io.Writer) {
+			for _, image := range images {
+				fmt.Fprintln(w, image.Image)
+			}
+		})
+	},
+}
+
+func init() {
+	imagesCmd.Flags().StringVarP(&imagesProfile, "profile", "p", "", "profile used to render the chart")
+}
+
+// packageImages renders the chart of a package and returns its deduplicated images, as they will be pulled
+func packageImages(packageName string, packageYaml *PackageYaml, profile string) ([]packageImage, error) {
+	manifests, err := renderChart(packageName, packageYaml, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	workloadImages, err := registry.Images(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	registryConf, err := registryConfig(packageYaml.PrivateRegistry, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byImage := map[string]*packageImage{}
+	for _, w := range workloadImages {
+		image := registryConf.RewriteImage(w.Image)
+		if byImage[image] == nil {
+			byImage[image] = &packageImage{Image: image}
+		}
+		workload := fmt.Sprintf("%s/%s", w.Kind, w.Name)
+		if w.Namespace != "" {
+			workload = fmt.Sprintf("%s/%s/%s", w.Kind, w.Namespace, w.Name)
+		}
+		if !contains(byImage[image].Workloads, workload) {
+			byImage[image].Workloads = append(byImage[image].Workloads, workload)
+		}
+	}
+
+	var result []packageImage
+	for _, image := range byImage {
+		result = append(result, *image)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Image < result[j].Image
+	})
+	return result, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,110 +1,330 @@
 package cmd
 
 import (
-	"fmt"
 	"io/ioutil"
-	"os"
 	"os/exec"
-	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"sigs.k8s.io/yaml"
 )
 
 var (
-	customOptions []string
-	profile       string
-	updateCrdOnly bool
+	customOptions    []string
+	answers          []string
+	valuesFiles      []string
+	profile          string
+	updateCrdOnly    bool
+	forceCRD         bool
+	privateRegistry  string
+	registryMirrors  []string
+	imagePullSecrets []string
+	installBundle    string
+	skipDependencies bool
+	namespace        string
+	createNamespace  bool
+	releaseName      string
+	lockfile         string
+	wait             bool
+	waitTimeout      time.Duration
 )
 
+type installOptions struct {
+	ReleaseName      string
+	Namespace        string
+	CreateNamespace  bool
+	Profile          string
+	Answers          []string
+	Values           []string
+	CustomOptions    []string
+	PrivateRegistry  string
+	RegistryMirrors  []string
+	ImagePullSecrets []string
+	Wait             bool
+	Timeout          time.Duration
+	ForceCRD         bool
+	// Lock is verified for the package before it is installed
+	Lock *Lockfile
+}
+
 var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "install packages",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			fmt.Println("Exact one argument is required")
-			os.Exit(1)
-		}
+	Args:  usageArgs(cobra.RangeArgs(1, 2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		packageName := args[0]
+		release := releaseName
+		cmdResult.Action = "install"
+		if updateCrdOnly {
+			cmdResult.Action = "update-crds"
+		}
+		if len(args) == 2 {
+			if release != "" && release != args[1] {
+				return usageError("release name %s doesn't match --release-name %s", args[1], release)
+			}
+			release = args[1]
+		}
 
-		packagePath := filepath.Join(os.Getenv("HOME"), LocalChartLocation, args[0], "package.yaml")
-		if _, err := os.Stat(packagePath); err != nil {
-			fmt.Printf("Can't locate package %v. Run `k3p update`.\n", args[0])
+		if installBundle != "" {
+			logrus.Infof("Reading packages from bundle %v", installBundle)
+			if _, err := importBundle(installBundle); err != nil {
+				return err
+			}
 		}
 
-		packageYamlData, err := ioutil.ReadFile(packagePath)
+		packageYaml, err := loadPackageYaml(packageName)
 		if err != nil {
-			handleError(err)
-		}
-		packageYaml := &PackageYaml{}
-		if err := yaml.Unmarshal(packageYamlData, packageYaml); err != nil {
-			handleError(err)
+			return err
 		}
 
-		if packageYaml.CRDManifest != "" && updateCrdOnly {
-			fmt.Println("Upgrading CRDs")
-			tmpfile, err := ioutil.TempFile("", fmt.Sprintf("%s-crd-", packageName))
-			if err != nil {
-				handleError(err)
-			}
+		values, err := readValuesFiles(valuesFiles)
+		if err != nil {
+			return err
+		}
 
-			if err := ioutil.WriteFile(tmpfile.Name(), []byte(packageYaml.CRDManifest), 0755); err != nil {
-				handleError(err)
-			}
+		lock, err := loadLockfile(lockfile, cmd.Flags().Changed("lockfile"))
+		if err != nil {
+			return err
+		}
 
-			kcmd := exec.Command("kubectl", "apply", "-f", tmpfile.Name())
-			if output, err := kcmd.CombinedOutput(); err != nil {
-				fmt.Println(string(output))
-				handleError(err)
+		if updateCrdOnly {
+			if err := reconcileCRDs(packageYaml.CRDManifest, forceCRD, waitTimeout); err != nil {
+				return err
 			}
-			return
+			return printResult(nil)
 		}
 
-		fmt.Println("Install helm releases")
-		// run helm install
-		var options []string
-		tmpfileValue, err := ioutil.TempFile("", fmt.Sprintf("%s-value-", packageName))
-		if err != nil {
-			handleError(err)
-		}
-		for name, prof := range packageYaml.ProfileOptions {
-			if profile != "" {
-				if profile == name {
-					if err := ioutil.WriteFile(tmpfileValue.Name(), []byte(prof.ValueYaml), 0755); err != nil {
-						return
-					}
-				}
-				continue
-			} else if prof.Default {
-				if err := ioutil.WriteFile(tmpfileValue.Name(), []byte(prof.ValueYaml), 0755); err != nil {
-					return
+		if !skipDependencies {
+			dependencies, err := resolveDependencies(packageName)
+			if err != nil {
+				return err
+			}
+			for _, dep := range dependencies {
+				logrus.Infof("Installing dependency %s", dep)
+				if err := installPackage(dep, installOptions{
+					Namespace:        dependencyNamespace(dep, targetNamespace(namespace, packageYaml)),
+					CreateNamespace:  createNamespace,
+					PrivateRegistry:  privateRegistry,
+					RegistryMirrors:  registryMirrors,
+					ImagePullSecrets: imagePullSecrets,
+					Wait:             wait,
+					Timeout:          waitTimeout,
+					ForceCRD:         forceCRD,
+					Lock:             lock,
+				}); err != nil {
+					return errors.Wrapf(err, "installing dependency %s", dep)
 				}
 			}
 		}
-		options = []string{"--values", tmpfileValue.Name()}
 
-		if packageYaml.PrivateRegistry.Key != "" && packageYaml.PrivateRegistry.Value != "" {
-			options = append(options, "--set", fmt.Sprintf("%s=%s", packageYaml.PrivateRegistry.Key, packageYaml.PrivateRegistry.Value))
+		if err := installPackage(packageName, installOptions{
+			ReleaseName:      release,
+			Namespace:        namespace,
+			CreateNamespace:  createNamespace,
+			Profile:          profile,
+			Answers:          answers,
+			Values:           values,
+			CustomOptions:    customOptions,
+			PrivateRegistry:  privateRegistry,
+			RegistryMirrors:  registryMirrors,
+			ImagePullSecrets: imagePullSecrets,
+			Wait:             wait,
+			Timeout:          waitTimeout,
+			ForceCRD:         forceCRD,
+			Lock:             lock,
+		}); err != nil {
+			return err
 		}
+		return printResult(nil)
+	},
+}
 
-		if len(customOptions) > 0 {
-			options = append(options, customOptions...)
+func init() {
+	installCmd.Flags().BoolVarP(&updateCrdOnly, "update-crd-only", "", false, "only update the CRDs of the package without installing the chart")
+	installCmd.Flags().BoolVarP(&forceCRD, "force-crd", "", false, "apply CRD changes even if they remove stored versions or narrow the schema")
+	installCmd.Flags().StringVarP(&profile, "profile", "p", "", "profile is a set of answer values for a helm chart")
+	installCmd.Flags().StringArrayVarP(&answers, "set", "", nil, "answer a question of the package or set a chart value, e.g. key=value. Kept for upgrades")
+	installCmd.Flags().StringArrayVarP(&valuesFiles, "values", "f", nil, "YAML file with custom chart values. Kept for upgrades")
+	installCmd.Flags().StringArrayVarP(&customOptions, "custom-options", "", nil, "pass custom helm options")
+	installCmd.Flags().StringVarP(&privateRegistry, "private-registry", "", "", "rewrite all images of the package to this registry")
+	installCmd.Flags().StringArrayVarP(&registryMirrors, "registry-mirror", "", nil, "rewrite images of a source registry to another registry, e.g. docker.io=registry.local")
+	installCmd.Flags().StringVarP(&installBundle, fromBundleFlag, "", "", "install from a bundle created by `k3p bundle` without network access")
+	installCmd.Flags().StringArrayVarP(&imagePullSecrets, "image-pull-secret", "", nil, "image pull secret added to every workload of the package")
+	installCmd.Flags().StringVarP(&releaseName, "release-name", "", "", "name of the helm release, defaults to the package name")
+	installCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "namespace to install the package into, defaults to the package's default namespace")
+	installCmd.Flags().BoolVarP(&createNamespace, "create-namespace", "", false, "create the namespace if it doesn't exist")
+	installCmd.Flags().BoolVarP(&wait, "wait", "", false, "wait until the workloads and CRDs of the package are ready")
+	installCmd.Flags().DurationVarP(&waitTimeout, "timeout", "", 5*time.Minute, "how long to wait with --wait, also bounds waiting for CRDs to be established")
+	installCmd.Flags().StringVarP(&lockfile, "lockfile", "", defaultLockfile, "install the package versions pinned by this lockfile if it exists")
+	installCmd.Flags().BoolVarP(&skipDependencies, "skip-dependencies", "", false, "don't install the packages this package depends on")
+}
+
+// installPackage installs or upgrades a helm release of a package and records which package it came from
+func installPackage(packageName string, opts installOptions) error {
+	packageYaml, err := loadPackageYaml(packageName)
+	if err != nil {
+		return err
+	}
+
+	if opts.Lock != nil {
+		if err := opts.Lock.Verify(packageName); err != nil {
+			return err
 		}
+	}
+
+	release := opts.ReleaseName
+	if release == "" {
+		release = packageName
+	}
+
+	ns, err := releaseNamespace(opts.Namespace, packageYaml)
+	if err != nil {
+		return err
+	}
+	if opts.CreateNamespace {
+		if err := ensureNamespace(ns); err != nil {
+			return err
+		}
+	}
+
+	index, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	indexPackage, _ := index.Find(packageName)
+
+	upgrade, err := helmReleaseExists(release, ns)
+	if err != nil {
+		return err
+	}
+	preHooks, postHooks := packageYaml.Hooks.PreInstall, packageYaml.Hooks.PostInstall
+	hookCtx := hookContext{
+		Phase:     "preInstall",
+		Package:   packageName,
+		Version:   indexPackage.Version,
+		Release:   release,
+		Namespace: ns,
+	}
+	if upgrade {
+		preHooks, postHooks = packageYaml.Hooks.PreUpgrade, packageYaml.Hooks.PostUpgrade
+		hookCtx.Phase = "preUpgrade"
+	}
+	if err := runHooks(preHooks, hookCtx); err != nil {
+		return err
+	}
 
-		chartDir := filepath.Join(os.Getenv("HOME"), LocalChartLocation, packageName, "chart")
-		helmArgs := append([]string{"upgrade"}, append(options, "--install", packageName, chartDir)...)
-		helmCmd := exec.Command("helm", helmArgs...)
-		output, err := helmCmd.CombinedOutput()
+	if err := reconcileCRDs(packageYaml.CRDManifest, opts.ForceCRD, opts.Timeout); err != nil {
+		return err
+	}
+
+	logrus.Infof("Install helm releases")
+	// run helm install
+	options, cleanupValues, err := writeValues(packageName, packageYaml, opts.Profile)
+	if err != nil {
+		return err
+	}
+	defer cleanupValues()
+
+	registryConf, err := registryConfig(packageYaml.PrivateRegistry, opts.PrivateRegistry, opts.RegistryMirrors, opts.ImagePullSecrets)
+	if err != nil {
+		return err
+	}
+	if registryConf.Enabled() {
+		postRenderer, cleanup, err := writePostRenderer(release, registryConf)
 		if err != nil {
-			fmt.Println(string(output))
-			handleError(err)
+			return err
 		}
-		fmt.Println(string(output))
-	},
+		defer cleanup()
+		options = append(options, "--post-renderer", postRenderer)
+	}
+
+	customValues, cleanupCustom, err := writeCustomValues(packageName, packageYaml, opts.Values, opts.Answers)
+	if err != nil {
+		return err
+	}
+	defer cleanupCustom()
+	options = append(options, customValues...)
+
+	if len(opts.CustomOptions) > 0 {
+		options = append(options, opts.CustomOptions...)
+	}
+
+	helmArgs := append([]string{"upgrade"}, append(namespaceArgs(ns, options...), "--install", release, chartDir(packageName))...)
+	helmCmd := exec.Command("helm", helmArgs...)
+	start := time.Now()
+	output, err := helmCmd.CombinedOutput()
+	logCommand("helm", helmArgs, start, err)
+	logrus.Debug(strings.TrimSpace(string(output)))
+	if err != nil {
+		return clusterError(errors.Wrapf(err, "helm upgrade of release %s failed", release))
+	}
+
+	revision, err := currentRevision(release, ns)
+	if err != nil {
+		return err
+	}
+	crdDigest, err := saveCRDManifest(release, ns, packageYaml.CRDManifest)
+	if err != nil {
+		return err
+	}
+
+	if err := saveRelease(Release{
+		Name:             release,
+		Namespace:        ns,
+		Package:          packageName,
+		Version:          indexPackage.Version,
+		Profile:          opts.Profile,
+		DependsOn:        packageYaml.DependsOn,
+		Answers:          opts.Answers,
+		Values:           opts.Values,
+		CustomOptions:    opts.CustomOptions,
+		PrivateRegistry:  opts.PrivateRegistry,
+		RegistryMirrors:  opts.RegistryMirrors,
+		ImagePullSecrets: opts.ImagePullSecrets,
+		Revision:         revision,
+		CRDDigest:        crdDigest,
+		Updated:          time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
+	action := "installed"
+	if upgrade {
+		action = "upgraded"
+	}
+	touched(action, "Release", ns, release)
+
+	if opts.Wait {
+		if err := waitForRelease(release, ns, packageYaml, opts.Timeout); err != nil {
+			return err
+		}
+	}
+
+	hookCtx.Phase = "postInstall"
+	if upgrade {
+		hookCtx.Phase = "postUpgrade"
+	}
+	return runHooks(postHooks, hookCtx)
 }
 
-func init() {
-	installCmd.Flags().BoolVarP(&updateCrdOnly, "update-crd-only", "", false, "only update the crd")
-	installCmd.Flags().StringVarP(&profile, "profile", "p", "", "profile is a set of answer values for a helm chart")
-	installCmd.Flags().StringArrayVarP(&customOptions, "custom-options", "", nil, "pass custom helm options")
+// dependencyNamespace returns the namespace a dependency is installed into when it doesn't define a default namespace
+func dependencyNamespace(packageName, parentNamespace string) string {
+	packageYaml, err := loadPackageYaml(packageName)
+	if err != nil || packageYaml.DefaultNamespace == "" {
+		return parentNamespace
+	}
+	return packageYaml.DefaultNamespace
+}
+
+func readValuesFiles(files []string) ([]string, error) {
+	var result []string
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, string(data))
+	}
+	return result, nil
 }
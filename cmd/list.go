@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	listNamespace string
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the package releases installed by k3p",
+	Annotations: map[string]string{
+		cacheLockAnnotation: cacheLockNone,
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		releases, err := listReleases()
+		if err != nil {
+			return err
+		}
+
+		result := []Release{}
+		for _, r := range releases {
+			if listNamespace == "" || r.Namespace == listNamespace {
+				result = append(result, r)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return releaseKey(result[i]) < releaseKey(result[j])
+		})
+
+		cmdResult.Data = result
+		return printResult(func(out io.Writer) {
+			w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "RELEASE\tNAMESPACE\tPACKAGE\tVERSION\tPROFILE\tREVISION\tUPDATED")
+			for _, r := range result {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", r.Name, r.Namespace, r.Package, r.Version, r.Profile, r.Revision, r.Updated)
+			}
+			w.Flush()
+		})
+	},
+}
+
+func init() {
+	listCmd.Flags().StringVarP(&listNamespace, "namespace", "n", "", "only list releases in this namespace")
+}
@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	lockFormatVersion = "k3p.io/lock/v1"
+	defaultLockfile   = "k3p.lock"
+	baseArchive       = "base.tgz"
+)
+
+var (
+	lockOutput       string
+	lockDesiredState string
+)
+
+// Lockfile pins the exact content of packages so installs are reproducible
+type Lockfile struct {
+	Version  string          `json:"version"`
+	Packages []LockedPackage `json:"packages"`
+}
+
+// LockedPackage records the version and content digests of a package in the local cache
+type LockedPackage struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	BaseDigest   string            `json:"baseDigest,omitempty"`
+	PatchDigests map[string]string `json:"patchDigests,omitempty"`
+	CRDDigest    string            `json:"crdDigest,omitempty"`
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock [package...]",
+	Short: "Pin the packages in the local cache to a lockfile for reproducible installs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		packages := args
+		if lockDesiredState != "" {
+			state, err := loadDesiredState(lockDesiredState)
+			if err != nil {
+				return err
+			}
+			for _, p := range state.Packages {
+				packages = append(packages, p.Name)
+			}
+		}
+
+		index, err := loadIndex()
+		if err != nil {
+			return err
+		}
+		if len(packages) == 0 {
+			for _, p := range index.Packages {
+				packages = append(packages, p.Name)
+			}
+		}
+
+		lock := &Lockfile{Version: lockFormatVersion}
+		locked := map[string]bool{}
+		for _, name := range packages {
+			dependencies, err := packageDependencies(name)
+			if err != nil {
+				return err
+			}
+			for _, p := range append(dependencies, name) {
+				if locked[p] {
+					continue
+				}
+				locked[p] = true
+				lp, err := lockPackage(index, p)
+				if err != nil {
+					return err
+				}
+				lock.Packages = append(lock.Packages, *lp)
+			}
+		}
+		sort.Slice(lock.Packages, func(i, j int) bool {
+			return lock.Packages[i].Name < lock.Packages[j].Name
+		})
+
+		data, err := yaml.Marshal(lock)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(lockOutput, data, 0644); err != nil {
+			return err
+		}
+		cmdResult.Action = "lock"
+		cmdResult.Data = lock
+		touched("written", "File", "", lockOutput)
+		return printResult(func(w io.Writer) {
+			fmt.Fprintf(w, "Locked %d packages in %s\n", len(lock.Packages), lockOutput)
+		})
+	},
+}
+
+func init() {
+	lockCmd.Flags().StringVarP(&lockOutput, "lockfile", "", defaultLockfile, "lockfile to write")
+	lockCmd.Flags().StringVarP(&lockDesiredState, "filename", "f", "", "lock the packages of a desired state file used with `k3p apply`")
+}
+
+// packageDependencies returns all packages a package depends on, directly or indirectly
+func packageDependencies(packageName string) ([]string, error) {
+	var result []string
+	seen := map[string]bool{packageName: true}
+	queue := []string{packageName}
+	for len(queue) > 0 {
+		packageYaml, err := loadPackageYaml(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, dep := range packageYaml.DependsOn {
+			if !seen[dep.Name] {
+				seen[dep.Name] = true
+				result = append(result, dep.Name)
+				queue = append(queue, dep.Name)
+			}
+		}
+	}
+	return result, nil
+}
+
+// lockPackage computes the digests of a package in the local cache
+func lockPackage(index *Index, packageName string) (*LockedPackage, error) {
+	indexPackage, ok := index.Find(packageName)
+	if !ok {
+		return nil, notFoundError("package %s is not in the local index, run k3p update", packageName)
+	}
+	packageYaml, err := loadPackageYaml(packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LockedPackage{
+		Name:    packageName,
+		Version: indexPackage.Version,
+	}
+	if result.BaseDigest, err = fileDigest(packageName, baseArchive); err != nil {
+		return nil, err
+	}
+	for _, patch := range packageYaml.Patches {
+		digest, err := fileDigest(packageName, patch.Name)
+		if err != nil {
+			return nil, err
+		}
+		if result.PatchDigests == nil {
+			result.PatchDigests = map[string]string{}
+		}
+		result.PatchDigests[patch.Name] = digest
+	}
+	if packageYaml.CRDManifest != "" {
+		result.CRDDigest = "sha256:" + sha256Hex([]byte(packageYaml.CRDManifest))
+	}
+	return result, nil
+}
+
+// fileDigest returns the sha256 digest of a file of a package in the cache. Packages cached by older versions of k3p
+// have no base archive and have to be updated before they can be locked.
+func fileDigest(packageName, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(packageDir(packageName), name))
+	if os.IsNotExist(err) {
+		return "", notFoundError("%s of package %s is not in the local cache, run k3p update", name, packageName)
+	} else if err != nil {
+		return "", err
+	}
+	return "sha256:" + sha256Hex(data), nil
+}
+
+// loadLockfile reads a lockfile. If the file doesn't exist and wasn't given explicitly, no lockfile is used.
+func loadLockfile(file string, explicit bool) (*Lockfile, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lock := &Lockfile{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, withExitCode(exitValidation, errors.Wrapf(err, "failed to parse %s", file))
+	}
+	if lock.Version != lockFormatVersion {
+		return nil, validationError("unsupported lockfile version %q in %s, expected %q", lock.Version, file, lockFormatVersion)
+	}
+	logrus.Infof("Using lockfile %s", file)
+	return lock, nil
+}
+
+func (l *Lockfile) Find(packageName string) (LockedPackage, bool) {
+	for _, p := range l.Packages {
+		if p.Name == packageName {
+			return p, true
+		}
+	}
+	return LockedPackage{}, false
+}
+
+// Verify fails if a package is not locked or the local cache doesn't match the lockfile
+func (l *Lockfile) Verify(packageName string) error {
+	locked, ok := l.Find(packageName)
+	if !ok {
+		return validationError("package %s is not in the lockfile, run k3p lock", packageName)
+	}
+	if locked.BaseDigest == "" {
+		return validationError("package %s has no base digest in the lockfile, run k3p lock", packageName)
+	}
+	for _, name := range sortedKeys(locked.PatchDigests) {
+		if locked.PatchDigests[name] == "" {
+			return validationError("patch %s of package %s has no digest in the lockfile, run k3p lock", name, packageName)
+		}
+	}
+
+	index, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	actual, err := lockPackage(index, packageName)
+	if err != nil {
+		return err
+	}
+
+	var mismatches []string
+	if actual.Version != locked.Version {
+		mismatches = append(mismatches, fmt.Sprintf("version is %s, locked %s", actual.Version, locked.Version))
+	}
+	if actual.BaseDigest != locked.BaseDigest {
+		mismatches = append(mismatches, fmt.Sprintf("base digest is %s, locked %s", actual.BaseDigest, locked.BaseDigest))
+	}
+	for _, name := range sortedKeys(locked.PatchDigests) {
+		if actual.PatchDigests[name] != locked.PatchDigests[name] {
+			mismatches = append(mismatches, fmt.Sprintf("patch %s digest is %s, locked %s", name, actual.PatchDigests[name], locked.PatchDigests[name]))
+		}
+	}
+	for _, name := range sortedKeys(actual.PatchDigests) {
+		if _, ok := locked.PatchDigests[name]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("patch %s is not locked", name))
+		}
+	}
+	if actual.CRDDigest != locked.CRDDigest {
+		mismatches = append(mismatches, fmt.Sprintf("CRD digest is %s, locked %s", actual.CRDDigest, locked.CRDDigest))
+	}
+
+	if len(mismatches) > 0 {
+		return validationError("package %s in the local cache doesn't match the lockfile:\n  %s", packageName, strings.Join(mismatches, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	redacted = "*****"
+)
+
+var (
+	verbosity int
+	logFormat string
+
+	// secretVariables are the variables of password questions, their --set values are redacted in logs
+	secretVariables = map[string]bool{}
+)
+
+func init() {
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbosity", "v", "log more details, -v logs external commands, -vv everything")
+	rootCmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", logFormatText, "log format, text or json")
+}
+
+// setupLogging configures logrus to log to stderr in the selected format and level
+func setupLogging() error {
+	switch logFormat {
+	case logFormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case logFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return usageError("unsupported log format %s, use text or json", logFormat)
+	}
+	logrus.SetOutput(os.Stderr)
+
+	switch {
+	case verbosity >= 2:
+		logrus.SetLevel(logrus.TraceLevel)
+	case verbosity == 1:
+		logrus.SetLevel(logrus.DebugLevel)
+	default:
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+	return nil
+}
+
+// registerSecrets marks the variables of the password questions of a package as secret
+func registerSecrets(packageYaml *PackageYaml) {
+	for _, q := range packageYaml.Questions {
+		if q.Type == "password" {
+			secretVariables[q.Variable] = true
+		}
+	}
+}
+
+// redactArgs replaces the values of secret variables given with --set
+func redactArgs(args []string) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+	for i := 0; i < len(result); i++ {
+		if result[i] == "--set" && i+1 < len(result) {
+			i++
+			result[i] = redactAnswer(result[i])
+		} else if strings.HasPrefix(result[i], "--set=") {
+			result[i] = "--set=" + redactAnswer(strings.TrimPrefix(result[i], "--set="))
+		}
+	}
+	return result
+}
+
+// redactAnswer redacts the secret values of a --set argument, which may set several comma separated variables
+func redactAnswer(answer string) string {
+	pairs := strings.Split(answer, ",")
+	for i, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 && secretVariables[parts[0]] {
+			pairs[i] = parts[0] + "=" + redacted
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// commandLine returns an external command as it is logged, with secrets redacted
+func commandLine(name string, args []string) string {
+	return strings.Join(append([]string{name}, redactArgs(args)...), " ")
+}
+
+// logCommand logs an external command and how long it took at debug level
+func logCommand(name string, args []string, start time.Time, err error) {
+	entry := logrus.WithField("duration", time.Since(start).Round(time.Millisecond).String())
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Debugf("Ran %s", commandLine(name, args))
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	createdByLabel = "k3p.io/created-by"
+)
+
+// namespaceArgs adds --namespace to the arguments of a helm or kubectl command if a namespace is given
+func namespaceArgs(namespace string, args ...string) []string {
+	if namespace == "" {
+		return args
+	}
+	return append(args, "--namespace", namespace)
+}
+
+// targetNamespace returns the namespace given on the command line, falling back to the default namespace of the package
+func targetNamespace(namespace string, packageYaml *PackageYaml) string {
+	if namespace != "" {
+		return namespace
+	}
+	return packageYaml.DefaultNamespace
+}
+
+// releaseNamespace returns the namespace a release is installed to: the target namespace or, without one, the
+// namespace of the current kubeconfig context, which helm uses without --namespace
+func releaseNamespace(namespace string, packageYaml *PackageYaml) (string, error) {
+	if ns := targetNamespace(namespace, packageYaml); ns != "" {
+		return ns, nil
+	}
+	return currentNamespace()
+}
+
+// ensureNamespace creates a namespace if it doesn't exist yet and labels it as created by k3p
+func ensureNamespace(namespace string) error {
+	output, err := kubectl(nil, "get", "namespace", namespace, "--ignore-not-found", "-o", "name")
+	if err != nil {
+		return err
+	}
+	if len(output) > 0 {
+		return nil
+	}
+
+	logrus.Infof("Creating namespace %s", namespace)
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata": map[string]interface{}{
+			"name": namespace,
+			"labels": map[string]string{
+				createdByLabel: "k3p",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := kubectl(manifest, "create", "-f", "-"); err != nil {
+		return err
+	}
+	touched("created", "Namespace", "", namespace)
+	return nil
+}
+
+// removeNamespaceIfEmpty deletes a namespace created by k3p once no releases or resources, e.g. PVCs, are left in it
+func removeNamespaceIfEmpty(namespace string) error {
+	output, err := kubectl(nil, "get", "namespace", namespace, "--ignore-not-found", "-o", "jsonpath={.metadata.labels.k3p\\.io/created-by}")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(output)) != "k3p" {
+		logrus.Infof("Namespace %s was not created by k3p, keeping it", namespace)
+		return nil
+	}
+
+	releases, err := helm("list", "--namespace", namespace, "--all", "-q")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(releases)) != "" {
+		logrus.Infof("Namespace %s still has helm releases, keeping it", namespace)
+		return nil
+	}
+
+	resources, err := namespaceResources(namespace)
+	if err != nil {
+		logrus.Warnf("Failed to list the resources in namespace %s, keeping it: %v", namespace, err)
+		return nil
+	}
+	if len(resources) > 0 {
+		logrus.Infof("Namespace %s is not empty, keeping it: %s", namespace, strings.Join(resources, ", "))
+		return nil
+	}
+
+	logrus.Infof("Removing namespace %s", namespace)
+	if _, err := kubectl(nil, "delete", "namespace", namespace); err != nil {
+		return err
+	}
+	touched("deleted", "Namespace", "", namespace)
+	return nil
+}
+
+// namespaceResources lists the resources of every namespaced type in a namespace, except events and the objects
+// kubernetes creates in every namespace
+func namespaceResources(namespace string) ([]string, error) {
+	output, err := kubectl(nil, "api-resources", "--namespaced", "--verbs=list", "-o", "name")
+	if err != nil {
+		return nil, err
+	}
+	var types []string
+	for _, t := range strings.Fields(string(output)) {
+		if t != "events" && t != "events.events.k8s.io" {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return nil, errors.New("kubectl api-resources found no namespaced resource types")
+	}
+
+	output, err = kubectl(nil, "get", strings.Join(types, ","), "--namespace", namespace, "--ignore-not-found", "-o", "name")
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, name := range strings.Fields(string(output)) {
+		if !defaultNamespaceObject(name) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// defaultNamespaceObject returns true for the objects kubernetes creates in every namespace, as listed by kubectl -o name
+func defaultNamespaceObject(name string) bool {
+	switch {
+	case name == "configmap/kube-root-ca.crt",
+		name == "serviceaccount/default",
+		strings.HasPrefix(name, "secret/default-token-"):
+		return true
+	}
+	return false
+}
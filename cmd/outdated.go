@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// outdatedRelease is an installed release whose package has a newer version in the local index
+type outdatedRelease struct {
+	Release   Release `json:"release"`
+	Available string  `json:"available"`
+}
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "List package releases with a newer package version in the local cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outdated, err := outdatedReleases()
+		if err != nil {
+			return err
+		}
+		cmdResult.Data = outdated
+		return printResult(func(out io.Writer) {
+			if len(outdated) == 0 {
+				fmt.Fprintln(out, "All releases are up to date")
+				return
+			}
+			w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "RELEASE\tNAMESPACE\tPACKAGE\tINSTALLED\tAVAILABLE")
+			for _, o := range outdated {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", o.Release.Name, o.Release.Namespace, o.Release.Package, o.Release.Version, o.Available)
+			}
+			w.Flush()
+		})
+	},
+}
+
+// outdatedReleases compares the package version recorded for each release with the version in the cached index
+func outdatedReleases() ([]outdatedRelease, error) {
+	releases, err := listReleases()
+	if err != nil {
+		return nil, err
+	}
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []outdatedRelease{}
+	for _, release := range releases {
+		available, ok := index.Find(release.Package)
+		if !ok || available.Version == "" || available.Version == release.Version {
+			continue
+		}
+		if release.Version != "" {
+			if cmp, err := compareVersions(available.Version, release.Version); err == nil && cmp <= 0 {
+				continue
+			}
+		}
+		result = append(result, outdatedRelease{
+			Release:   release,
+			Available: available.Version,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var (
+	outputFormat string
+
+	// cmdResult collects what the running command did, it is printed once the command is done
+	cmdResult     = &Result{}
+	resultPrinted bool
+)
+
+// Result is what a command did, printed in the format selected with --output
+type Result struct {
+	Command   string           `json:"command"`
+	Action    string           `json:"action,omitempty"`
+	Resources []ResourceChange `json:"resources,omitempty"`
+	Data      interface{}      `json:"data,omitempty"`
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+// ResourceChange is a resource a command created, updated or deleted
+type ResourceChange struct {
+	Action string `json:"action"`
+	resourceRef
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "output format, table, json or yaml. Progress is logged to stderr")
+}
+
+// checkOutputFormat fails if --output is not a supported format
+func checkOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return usageError("unsupported output format %s, use table, json or yaml", outputFormat)
+}
+
+// structuredOutput returns true if the result is printed as JSON or YAML instead of a table
+func structuredOutput() bool {
+	return outputFormat == outputJSON || outputFormat == outputYAML
+}
+
+// touched records a resource the command changed
+func touched(action, kind, namespace, name string) {
+	cmdResult.Resources = append(cmdResult.Resources, ResourceChange{
+		Action: action,
+		resourceRef: resourceRef{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+		},
+	})
+}
+
+// printResult prints the result of the command. In table output, table renders the data of the result; without it
+// the touched resources are listed.
+func printResult(table func(w io.Writer)) error {
+	resultPrinted = true
+	switch outputFormat {
+	case outputJSON:
+		data, err := json.MarshalIndent(cmdResult, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case outputYAML:
+		data, err := yaml.Marshal(cmdResult)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	default:
+		if table != nil {
+			table(os.Stdout)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		for _, r := range cmdResult.Resources {
+			fmt.Fprintf(w, "%s\t%s\n", r.Action, r.resourceRef)
+		}
+		w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// validatePackageName rejects names from indexes and bundles that are not a single directory in the cache
+func validatePackageName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return validationError("invalid package name %q", name)
+	}
+	return nil
+}
+
+func packageDir(packageName string) string {
+	return filepath.Join(cacheDir(), packageName)
+}
+
+func chartDir(packageName string) string {
+	return filepath.Join(packageDir(packageName), "chart")
+}
+
+func loadPackageYaml(packageName string) (*PackageYaml, error) {
+	packagePath := filepath.Join(packageDir(packageName), "package.yaml")
+	packageYamlData, err := ioutil.ReadFile(packagePath)
+	if os.IsNotExist(err) {
+		return nil, notFoundError("can't locate package %v. Run `k3p update`", packageName)
+	} else if err != nil {
+		return nil, err
+	}
+	packageYaml := &PackageYaml{}
+	if err := yaml.Unmarshal(packageYamlData, packageYaml); err != nil {
+		return nil, withExitCode(exitValidation, errors.Wrapf(err, "failed to parse package.yaml of %s", packageName))
+	}
+	return packageYaml, nil
+}
+
+// loadIndex reads the index saved by the last `k3p update`
+func loadIndex() (*Index, error) {
+	index := &Index{}
+	indexData, err := ioutil.ReadFile(filepath.Join(cacheDir(), "index.yaml"))
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(indexData, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func saveIndex(index *Index) error {
+	indexData, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cacheDir(), "index.yaml"), indexData, 0644)
+}
+
+func (i *Index) Find(packageName string) (IndexPackage, bool) {
+	for _, p := range i.Packages {
+		if p.Name == packageName {
+			return p, true
+		}
+	}
+	return IndexPackage{}, false
+}
+
+// Merge adds the packages of other to the index, replacing packages with the same name
+func (i *Index) Merge(other *Index) {
+	for _, p := range other.Packages {
+		replaced := false
+		for j := range i.Packages {
+			if i.Packages[j].Name == p.Name {
+				i.Packages[j] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			i.Packages = append(i.Packages, p)
+		}
+	}
+}
+
+// profileValues returns the value yaml of the named profile, or of the default profile if no name is given
+func profileValues(packageYaml *PackageYaml, profile string) (string, error) {
+	if profile != "" {
+		prof, ok := packageYaml.ProfileOptions[profile]
+		if !ok {
+			return "", validationError("profile %s is not defined by the package", profile)
+		}
+		return prof.ValueYaml, nil
+	}
+	for _, prof := range packageYaml.ProfileOptions {
+		if prof.Default {
+			return prof.ValueYaml, nil
+		}
+	}
+	return "", nil
+}
+
+// writeValues writes the values of the selected profile to a temp file and returns its helm arguments
+func writeValues(packageName string, packageYaml *PackageYaml, profile string) ([]string, func(), error) {
+	values, err := profileValues(packageYaml, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpfileValue, err := ioutil.TempFile("", fmt.Sprintf("%s-value-", packageName))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tmpfileValue.Close()
+	cleanup := func() {
+		os.Remove(tmpfileValue.Name())
+	}
+
+	if _, err := tmpfileValue.WriteString(values); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	options := []string{"--values", tmpfileValue.Name()}
+	if packageYaml.PrivateRegistry.Key != "" && packageYaml.PrivateRegistry.Value != "" {
+		options = append(options, "--set", fmt.Sprintf("%s=%s", packageYaml.PrivateRegistry.Key, packageYaml.PrivateRegistry.Value))
+	}
+	return options, cleanup, nil
+}
+
+// writeCustomValues writes the values given by the user to temporary files and returns them as helm options, followed by
+// the answers as --set options so they take precedence. Answers to password questions are redacted in logs.
+func writeCustomValues(packageName string, packageYaml *PackageYaml, values, answers []string) ([]string, func(), error) {
+	registerSecrets(packageYaml)
+	var files []string
+	cleanup := func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+
+	var options []string
+	for _, v := range values {
+		tmpfile, err := ioutil.TempFile("", fmt.Sprintf("%s-custom-value-", packageName))
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		files = append(files, tmpfile.Name())
+		_, err = tmpfile.WriteString(v)
+		tmpfile.Close()
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		options = append(options, "--values", tmpfile.Name())
+	}
+	for _, answer := range answers {
+		options = append(options, "--set", answer)
+	}
+	return options, cleanup, nil
+}
+
+// renderChart renders the chart of a package with the values of the selected profile
+func renderChart(packageName string, packageYaml *PackageYaml, profile string) ([]byte, error) {
+	return renderRelease(packageName, "", packageName, packageYaml, profile, nil, nil)
+}
+
+// renderRelease renders the chart of a package for a release in a namespace with the custom values and answers of the release
+func renderRelease(release, namespace, packageName string, packageYaml *PackageYaml, profile string, values, answers []string) ([]byte, error) {
+	options, cleanup, err := writeValues(packageName, packageYaml, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	customOptions, cleanupCustom, err := writeCustomValues(packageName, packageYaml, values, answers)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupCustom()
+	options = append(options, customOptions...)
+
+	helmArgs := append([]string{"template", release, chartDir(packageName)}, namespaceArgs(namespace, options...)...)
+	helmCmd := exec.Command("helm", helmArgs...)
+	helmCmd.Stderr = os.Stderr
+	start := time.Now()
+	output, err := helmCmd.Output()
+	logCommand("helm", helmArgs, start, err)
+	return output, err
+}
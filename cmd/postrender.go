@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rancher/k3p/pkg/registry"
+	"github.com/spf13/cobra"
+)
+
+var (
+	postRenderConfig string
+)
+
+// postRenderCmd is invoked by helm through --post-renderer to rewrite the rendered manifests
+var postRenderCmd = &cobra.Command{
+	Use:   "post-render",
+	Short: "Rewrite images of rendered manifests read from stdin",
+	Annotations: map[string]string{
+		cacheLockAnnotation: cacheLockNone,
+	},
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configData, err := ioutil.ReadFile(postRenderConfig)
+		if err != nil {
+			return err
+		}
+		config := registry.Config{}
+		if err := json.Unmarshal(configData, &config); err != nil {
+			return err
+		}
+
+		manifests, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		result, err := registry.RewriteManifests(manifests, config)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(result); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	postRenderCmd.Flags().StringVarP(&postRenderConfig, "config", "", "", "registry rewrite configuration")
+}
+
+// registryConfig merges the private registry setting of a package with the registry flags given on the command line
+func registryConfig(setting PrivateRegistrySetting, registryOverride string, mirrors, pullSecrets []string) (registry.Config, error) {
+	config := registry.Config{
+		Mirrors: map[string]string{},
+	}
+	if setting.RewriteImages {
+		config.Registry = setting.Value
+	}
+	for k, v := range setting.Mirrors {
+		config.Mirrors[k] = v
+	}
+	config.ImagePullSecrets = append(config.ImagePullSecrets, setting.ImagePullSecrets...)
+
+	if registryOverride != "" {
+		config.Registry = registryOverride
+	}
+	for _, mirror := range mirrors {
+		parts := strings.SplitN(mirror, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return config, usageError("invalid registry mirror %q, expected <source-registry>=<registry>", mirror)
+		}
+		config.Mirrors[parts[0]] = parts[1]
+	}
+	config.ImagePullSecrets = append(config.ImagePullSecrets, pullSecrets...)
+
+	return config, nil
+}
+
+// writePostRenderer writes the registry config and a wrapper script that helm can run as --post-renderer.
+// The returned cleanup function removes both files.
+func writePostRenderer(name string, config registry.Config) (string, func(), error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, err
+	}
+
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return "", nil, err
+	}
+	configFile, err := ioutil.TempFile("", fmt.Sprintf("%s-registry-", name))
+	if err != nil {
+		return "", nil, err
+	}
+	defer configFile.Close()
+	if _, err := configFile.Write(configData); err != nil {
+		os.Remove(configFile.Name())
+		return "", nil, err
+	}
+
+	script, err := ioutil.TempFile("", fmt.Sprintf("%s-post-render-", name))
+	if err != nil {
+		os.Remove(configFile.Name())
+		return "", nil, err
+	}
+	defer script.Close()
+
+	cleanup := func() {
+		os.Remove(configFile.Name())
+		os.Remove(script.Name())
+	}
+
+	content := fmt.Sprintf("#!/bin/sh\nexec %q post-render --config %q\n", self, configFile.Name())
+	if _, err := script.WriteString(content); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := script.Chmod(0755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return script.Name(), cleanup, nil
+}
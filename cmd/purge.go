@@ -3,56 +3,130 @@ package cmd
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"sigs.k8s.io/yaml"
+)
+
+var (
+	purgeNamespace  string
+	purgeYes        bool
+	purgeForce      bool
+	purgeBackupFile string
 )
 
 var purgeCmd = &cobra.Command{
 	Use:   "purge",
 	Short: "Purge CRD configuration for a package",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			fmt.Println("Exact one argument is required")
-			os.Exit(1)
-		}
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		packageName := args[0]
+		cmdResult.Action = "purge"
 
-		packagePath := filepath.Join(os.Getenv("HOME"), LocalChartLocation, args[0], "package.yaml")
-		if _, err := os.Stat(packagePath); err != nil {
-			fmt.Printf("Can't locate package %v. Run `k3p update`.\n", args[0])
+		packageYaml, err := loadPackageYaml(packageName)
+		if err != nil {
+			return err
 		}
+		ns := targetNamespace(purgeNamespace, packageYaml)
 
-		packageYamlData, err := ioutil.ReadFile(packagePath)
+		releases, err := packageReleases(packageName, ns)
 		if err != nil {
-			handleError(err)
+			return err
 		}
-		packageYaml := &PackageYaml{}
-		if err := yaml.Unmarshal(packageYamlData, packageYaml); err != nil {
-			handleError(err)
+		if len(releases) > 0 && !purgeForce {
+			return validationError("package %s is still installed as release %s. Delete it first or use --force to purge anyway",
+				packageName, strings.Join(releases, ", "))
 		}
 
-		if packageYaml.CRDManifest != "" {
-			fmt.Println("Purging CRDs")
-			tmpfile, err := ioutil.TempFile("", fmt.Sprintf("%s-crd-", packageName))
+		resources, err := listCustomResources(packageYaml.CRDManifest)
+		if err != nil {
+			return err
+		}
+		total := printCustomResourceCounts(resources)
+
+		if len(resources) > 0 && !purgeYes {
+			ok, err := confirm(fmt.Sprintf("Purging package %s deletes %d CRDs and %d custom resources in all namespaces. Continue?",
+				packageName, len(resources), total))
 			if err != nil {
-				handleError(err)
+				return err
+			}
+			if !ok {
+				cmdResult.Action = "none"
+				return fmt.Errorf("purge of package %s aborted", packageName)
 			}
+		}
 
-			if err := ioutil.WriteFile(tmpfile.Name(), []byte(packageYaml.CRDManifest), 0755); err != nil {
-				handleError(err)
+		if purgeBackupFile != "" {
+			data, err := objectsYaml(sortedCustomResources(resources))
+			if err != nil {
+				return err
 			}
+			if err := ioutil.WriteFile(purgeBackupFile, data, 0600); err != nil {
+				return err
+			}
+			logrus.Infof("Saved %d custom resources to %s", total, purgeBackupFile)
+		}
 
-			kcmd := exec.Command("kubectl", "delete", "-f", tmpfile.Name())
-			output, err := kcmd.CombinedOutput()
+		if err := runHooks(packageYaml.Hooks.PrePurge, hookContext{
+			Phase:     "prePurge",
+			Package:   packageName,
+			Release:   packageName,
+			Namespace: ns,
+		}); err != nil {
+			return err
+		}
+
+		if packageYaml.CRDManifest != "" {
+			logrus.Infof("Purging CRDs")
+			output, err := kubectl([]byte(packageYaml.CRDManifest), "delete", "-f", "-", "--ignore-not-found")
 			if err != nil {
-				fmt.Println(string(output))
-				handleError(err)
+				return err
+			}
+			logrus.Debug(strings.TrimSpace(string(output)))
+			for _, obj := range sortedCustomResources(resources) {
+				touched("deleted", nestedString(obj, "kind"), nestedString(obj, "metadata", "namespace"), nestedString(obj, "metadata", "name"))
+			}
+			for _, r := range resources {
+				touched("deleted", "CustomResourceDefinition", "", r.CRD.Name)
+			}
+		}
+
+		if ns != "" {
+			if err := removeNamespaceIfEmpty(ns); err != nil {
+				return err
 			}
-			fmt.Println(string(output))
 		}
+		return printResult(nil)
 	},
 }
+
+func init() {
+	purgeCmd.Flags().StringVarP(&purgeNamespace, "namespace", "n", "", "namespace of the package, removed once empty if it was created by k3p")
+	purgeCmd.Flags().BoolVarP(&purgeYes, "yes", "y", false, "don't ask for confirmation before deleting custom resources")
+	purgeCmd.Flags().BoolVarP(&purgeForce, "force", "", false, "purge even if a release of the package is still installed")
+	purgeCmd.Flags().StringVarP(&purgeBackupFile, "backup-file", "", "", "save all custom resources of the package to this YAML file before deleting them")
+}
+
+// packageReleases returns the releases of a package that are still installed
+func packageReleases(packageName, namespace string) ([]string, error) {
+	records, err := listReleases()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, r := range records {
+		if r.Package == packageName {
+			result = append(result, releaseKey(r))
+		}
+	}
+	if len(result) > 0 {
+		return result, nil
+	}
+
+	exists, err := helmReleaseExists(packageName, namespace)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return []string{packageName}, nil
+}
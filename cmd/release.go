@@ -0,0 +1,442 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	releaseLabel      = "k3p.io/release"
+	releaseDataKey    = "release.yaml"
+	historyDataKey    = "history.yaml"
+	maxReleaseHistory = 10
+)
+
+// Release records which package a helm release was installed from. It is stored as a ConfigMap next to the release,
+// the values it was installed with in a Secret of the same name.
+type Release struct {
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace,omitempty"`
+	Package   string       `json:"package"`
+	Version   string       `json:"version,omitempty"`
+	Profile   string       `json:"profile,omitempty"`
+	DependsOn []Dependency `json:"dependsOn,omitempty"`
+	// Answers are the --set values, Values the content of the --values files and CustomOptions the --custom-options
+	// given on install
+	Answers       []string `json:"-"`
+	Values        []string `json:"-"`
+	CustomOptions []string `json:"-"`
+
+	PrivateRegistry  string   `json:"privateRegistry,omitempty"`
+	RegistryMirrors  []string `json:"registryMirrors,omitempty"`
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+
+	// Revision is the helm revision this record belongs to
+	Revision  int    `json:"revision,omitempty"`
+	CRDDigest string `json:"crdDigest,omitempty"`
+	Updated   string `json:"updated,omitempty"`
+}
+
+// releaseValues are the values of a revision of a release. Answers may be passwords, so they are stored in a Secret
+// rather than in the ConfigMap of the release.
+type releaseValues struct {
+	Revision      int      `json:"revision,omitempty"`
+	Answers       []string `json:"answers,omitempty"`
+	Values        []string `json:"values,omitempty"`
+	CustomOptions []string `json:"customOptions,omitempty"`
+}
+
+func (r Release) values() releaseValues {
+	return releaseValues{
+		Revision:      r.Revision,
+		Answers:       r.Answers,
+		Values:        r.Values,
+		CustomOptions: r.CustomOptions,
+	}
+}
+
+func (r *Release) setValues(values releaseValues) {
+	r.Answers, r.Values, r.CustomOptions = values.Answers, values.Values, values.CustomOptions
+}
+
+// releaseConfigMapName is the name of the ConfigMap and the Secret of a release
+func releaseConfigMapName(name string) string {
+	return fmt.Sprintf("k3p-release-%s", name)
+}
+
+// saveRelease records a release and adds it to the history of the release, replacing an entry of the same revision.
+// The oldest revisions are dropped from the history if it doesn't fit into a ConfigMap or Secret.
+func saveRelease(release Release) error {
+	history, err := releaseHistory(release.Name, release.Namespace)
+	if err != nil {
+		return err
+	}
+	var newHistory []Release
+	for _, r := range history {
+		if r.Revision != release.Revision {
+			newHistory = append(newHistory, r)
+		}
+	}
+	newHistory = append(newHistory, release)
+	if len(newHistory) > maxReleaseHistory {
+		newHistory = newHistory[len(newHistory)-maxReleaseHistory:]
+	}
+
+	var manifests [][]byte
+	for {
+		if manifests, err = releaseObjects(release, newHistory); err != nil {
+			return err
+		}
+		if len(manifests[0]) <= maxObjectSize && len(manifests[1]) <= maxObjectSize {
+			break
+		}
+		if len(newHistory) == 1 {
+			return validationError("the record of release %s is larger than the %d bytes a ConfigMap or Secret can hold, pass fewer or smaller values", release.Name, maxObjectSize)
+		}
+		logrus.Debugf("Dropping revision %d from the history of release %s, it exceeds %d bytes", newHistory[0].Revision, release.Name, maxObjectSize)
+		newHistory = newHistory[1:]
+	}
+	for _, manifest := range manifests {
+		if err := saveObject(manifest); err != nil {
+			return err
+		}
+	}
+	return pruneCRDManifests(release.Name, release.Namespace, newHistory)
+}
+
+// releaseObjects returns the ConfigMap and the Secret of a release with its history
+func releaseObjects(release Release, history []Release) ([][]byte, error) {
+	configMapData, secretData, err := releaseData(release, history)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{
+		"name": releaseConfigMapName(release.Name),
+		"labels": map[string]string{
+			releaseLabel: release.Name,
+		},
+	}
+	if release.Namespace != "" {
+		metadata["namespace"] = release.Namespace
+	}
+	configMap := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata,
+		"data": map[string]string{
+			releaseDataKey: string(configMapData[releaseDataKey]),
+			historyDataKey: string(configMapData[historyDataKey]),
+		},
+	}
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata":   metadata,
+		"data":       secretData,
+	}
+	var result [][]byte
+	for _, obj := range []map[string]interface{}{configMap, secret} {
+		manifest, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, manifest)
+	}
+	return result, nil
+}
+
+// pruneCRDManifests deletes the CRD manifests of a release no revision in its history was installed with
+func pruneCRDManifests(name, namespace string, history []Release) error {
+	keep := map[string]bool{}
+	for _, r := range history {
+		if r.CRDDigest != "" {
+			keep["configmap/"+crdConfigMapName(name, r.CRDDigest)] = true
+		}
+	}
+	output, err := kubectl(nil, namespaceArgs(namespace, "get", "configmap", "-l", crdReleaseLabel+"="+name, "-o", "name")...)
+	if err != nil {
+		return err
+	}
+	for _, obj := range strings.Fields(string(output)) {
+		if keep[obj] {
+			continue
+		}
+		logrus.Debugf("Deleting %s of release %s, no revision in its history uses it", obj, name)
+		if _, err := kubectl(nil, namespaceArgs(namespace, "delete", obj, "--ignore-not-found")...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseData returns the data of the ConfigMap and the Secret of a release with its history
+func releaseData(release Release, history []Release) (map[string][]byte, map[string][]byte, error) {
+	var historyValues []releaseValues
+	for _, r := range history {
+		historyValues = append(historyValues, r.values())
+	}
+
+	configMapData := map[string][]byte{}
+	secretData := map[string][]byte{}
+	for _, d := range []struct {
+		data map[string][]byte
+		key  string
+		obj  interface{}
+	}{
+		{configMapData, releaseDataKey, release},
+		{configMapData, historyDataKey, history},
+		{secretData, releaseDataKey, release.values()},
+		{secretData, historyDataKey, historyValues},
+	} {
+		data, err := yaml.Marshal(d.obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		d.data[d.key] = data
+	}
+	return configMapData, secretData, nil
+}
+
+// releaseSecret is the Secret with the values of a release
+type releaseSecret struct {
+	Metadata struct {
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+// getReleaseSecret returns the Secret with the values of a release, or nil if there is none
+func getReleaseSecret(name, namespace string) (*releaseSecret, error) {
+	output, err := kubectl(nil, namespaceArgs(namespace, "get", "secret", releaseConfigMapName(name), "--ignore-not-found", "-o", "json")...)
+	if err != nil || len(output) == 0 {
+		return nil, err
+	}
+	secret := &releaseSecret{}
+	return secret, json.Unmarshal(output, secret)
+}
+
+// setReleaseValues reads the values of a release from its Secret
+func setReleaseValues(release *Release, secret *releaseSecret) error {
+	if secret == nil {
+		return nil
+	}
+	values := releaseValues{}
+	if err := yaml.Unmarshal(secret.Data[releaseDataKey], &values); err != nil {
+		return err
+	}
+	release.setValues(values)
+	return nil
+}
+
+// getRelease returns the recorded release, or nil if the release was not installed by k3p
+func getRelease(name, namespace string) (*Release, error) {
+	output, err := kubectl(nil, namespaceArgs(namespace, "get", "configmap", releaseConfigMapName(name), "--ignore-not-found", "-o", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		return nil, nil
+	}
+	release, err := parseReleaseConfigMap(output)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := getReleaseSecret(name, release.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return release, setReleaseValues(release, secret)
+}
+
+func listReleases() ([]Release, error) {
+	output, err := kubectl(nil, "get", "configmap", "--all-namespaces", "-l", releaseLabel, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	list := struct {
+		Items []json.RawMessage `json:"items"`
+	}{}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, err
+	}
+
+	output, err = kubectl(nil, "get", "secret", "--all-namespaces", "-l", releaseLabel, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	secrets := struct {
+		Items []releaseSecret `json:"items"`
+	}{}
+	if err := json.Unmarshal(output, &secrets); err != nil {
+		return nil, err
+	}
+
+	var result []Release
+	for _, item := range list.Items {
+		release, err := parseReleaseConfigMap(item)
+		if err != nil {
+			return nil, err
+		}
+		for i, secret := range secrets.Items {
+			if secret.Metadata.Namespace == release.Namespace && secret.Metadata.Labels[releaseLabel] == release.Name {
+				if err := setReleaseValues(release, &secrets.Items[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result = append(result, *release)
+	}
+	return result, nil
+}
+
+// findRelease looks up a release by name. If no namespace is given, the release is searched in all namespaces.
+func findRelease(name, namespace string) (*Release, error) {
+	if namespace != "" {
+		return getRelease(name, namespace)
+	}
+
+	releases, err := listReleases()
+	if err != nil {
+		return nil, err
+	}
+	var result *Release
+	for i, release := range releases {
+		if release.Name != name {
+			continue
+		}
+		if result != nil {
+			return nil, usageError("release %s exists in namespaces %s and %s, use --namespace to select one", name, result.Namespace, release.Namespace)
+		}
+		result = &releases[i]
+	}
+	return result, nil
+}
+
+// helmReleaseExists checks whether a helm release exists, whether or not it was installed by k3p
+func helmReleaseExists(name, namespace string) (bool, error) {
+	output, err := helm(namespaceArgs(namespace, "list", "--all", "-q", "--filter", fmt.Sprintf("^%s$", regexp.QuoteMeta(name)))...)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
+// requireRelease looks up a release installed by k3p and fails if there is none
+func requireRelease(name, namespace string) (*Release, error) {
+	release, err := findRelease(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return nil, notFoundError("release %s was not installed by k3p", name)
+	}
+	return release, nil
+}
+
+func deleteRelease(release Release) error {
+	if _, err := kubectl(nil, namespaceArgs(release.Namespace, "delete", "configmap,secret", releaseConfigMapName(release.Name), "--ignore-not-found")...); err != nil {
+		return err
+	}
+	_, err := kubectl(nil, namespaceArgs(release.Namespace, "delete", "configmap", "-l", crdReleaseLabel+"="+release.Name, "--ignore-not-found")...)
+	return err
+}
+
+// releaseHistory returns the recorded revisions of a release, oldest first
+func releaseHistory(name, namespace string) ([]Release, error) {
+	output, err := kubectl(nil, namespaceArgs(namespace, "get", "configmap", releaseConfigMapName(name), "--ignore-not-found", "-o", "json")...)
+	if err != nil || len(output) == 0 {
+		return nil, err
+	}
+
+	configMap := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	if err := json.Unmarshal(output, &configMap); err != nil {
+		return nil, err
+	}
+	var history []Release
+	if err := yaml.Unmarshal([]byte(configMap.Data[historyDataKey]), &history); err != nil {
+		return nil, err
+	}
+
+	secret, err := getReleaseSecret(name, namespace)
+	if err != nil || secret == nil {
+		return history, err
+	}
+	var values []releaseValues
+	if err := yaml.Unmarshal(secret.Data[historyDataKey], &values); err != nil {
+		return nil, err
+	}
+	for i := range history {
+		for _, v := range values {
+			if v.Revision == history[i].Revision {
+				history[i].setValues(v)
+			}
+		}
+	}
+	return history, nil
+}
+
+func parseReleaseConfigMap(data []byte) (*Release, error) {
+	configMap := struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Data map[string]string `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &configMap); err != nil {
+		return nil, err
+	}
+
+	release := &Release{}
+	if err := yaml.Unmarshal([]byte(configMap.Data[releaseDataKey]), release); err != nil {
+		return nil, err
+	}
+	if release.Namespace == "" {
+		release.Namespace = configMap.Metadata.Namespace
+	}
+	return release, nil
+}
+
+// helmRevision is a revision of a helm release as listed by helm history
+type helmRevision struct {
+	Revision    int    `json:"revision"`
+	Updated     string `json:"updated"`
+	Status      string `json:"status"`
+	Chart       string `json:"chart"`
+	AppVersion  string `json:"app_version"`
+	Description string `json:"description"`
+}
+
+func helmHistory(name, namespace string) ([]helmRevision, error) {
+	output, err := helm(namespaceArgs(namespace, "history", name, "-o", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	var result []helmRevision
+	return result, json.Unmarshal(output, &result)
+}
+
+// currentRevision returns the latest revision of a helm release
+func currentRevision(name, namespace string) (int, error) {
+	history, err := helmHistory(name, namespace)
+	if err != nil {
+		return 0, err
+	}
+	revision := 0
+	for _, h := range history {
+		if h.Revision > revision {
+			revision = h.Revision
+		}
+	}
+	return revision, nil
+}
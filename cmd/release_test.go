@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+// releaseKubectl answers for the ConfigMap and Secret of release foo from files and saves created objects to a file
+const releaseKubectl = `dir=$(dirname "$0")
+case "$*" in
+  "get configmap k3p-release-foo"*) cat "$dir/configmap.json";;
+  "get secret k3p-release-foo"*) cat "$dir/secret.json";;
+  "get configmap -l"*) ;;
+  "create -f -") cat >> "$dir/created";;
+  *) exit 1;;
+esac
+`
+
+func TestSaveReleaseTrimsLargeHistory(t *testing.T) {
+	withFakeCluster(t, releaseKubectl, func(cache string) {
+		bin := filepath.Join(filepath.Dir(cache), "bin")
+		large := strings.Repeat("x", 300*1024)
+
+		var history []Release
+		var values []releaseValues
+		for revision := 1; revision <= 3; revision++ {
+			history = append(history, Release{Name: "foo", Package: "foo", Revision: revision})
+			values = append(values, releaseValues{Revision: revision, Values: []string{large}})
+		}
+		historyData, err := yaml.Marshal(history)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valuesData, err := yaml.Marshal(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for file, obj := range map[string]interface{}{
+			"configmap.json": map[string]interface{}{"data": map[string]string{historyDataKey: string(historyData)}},
+			"secret.json":    map[string]interface{}{"data": map[string][]byte{historyDataKey: valuesData}},
+		} {
+			data, err := json.Marshal(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(bin, file), string(data))
+		}
+
+		if err := saveRelease(Release{Name: "foo", Package: "foo", Revision: 4, Values: []string{large}}); err != nil {
+			t.Fatal(err)
+		}
+		created, err := ioutil.ReadFile(filepath.Join(bin, "created"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(created), "last-applied-configuration") {
+			t.Error("the release was saved with the last applied configuration")
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(string(created)))
+		var secret releaseSecret
+		for decoder.More() {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(raw), `"kind":"Secret"`) {
+				if err := json.Unmarshal(raw, &secret); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		var saved []releaseValues
+		if err := yaml.Unmarshal(secret.Data[historyDataKey], &saved); err != nil {
+			t.Fatal(err)
+		}
+		if len(saved) == 0 || len(saved) == 4 || saved[len(saved)-1].Revision != 4 {
+			t.Errorf("expected the oldest revisions to be dropped and revision 4 to be kept, got %d revisions", len(saved))
+		}
+	})
+}
+
+func TestSaveReleaseTooLarge(t *testing.T) {
+	withFakeCluster(t, releaseKubectl, func(cache string) {
+		bin := filepath.Join(filepath.Dir(cache), "bin")
+		writeTestFile(t, filepath.Join(bin, "configmap.json"), "")
+		writeTestFile(t, filepath.Join(bin, "secret.json"), "")
+
+		err := saveRelease(Release{Name: "foo", Package: "foo", Revision: 1, Values: []string{strings.Repeat("x", maxObjectSize)}})
+		if err == nil || exitCode(err) != exitValidation {
+			t.Errorf("expected a validation error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	restoreTimeout time.Duration
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the CRDs and custom resources of a backup created by `k3p backup`",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, files, err := readBackup(args[0])
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Restoring package %s from backup created %s", manifest.Package, manifest.Created)
+
+		if err := restoreCRDs(files, restoreTimeout); err != nil {
+			return err
+		}
+
+		conflicts, err := restoreResources(files)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			logrus.Warnf("%d custom resources already exist and were not restored:\n  %s", len(conflicts), strings.Join(conflicts, "\n  "))
+		}
+
+		if manifest.Release != nil {
+			logrus.Infof("The helm values of release %s are in %s of the backup", manifest.Release.Name, backupValuesFile)
+		}
+		cmdResult.Action = "restore"
+		return printResult(nil)
+	},
+}
+
+func init() {
+	restoreCmd.Flags().DurationVarP(&restoreTimeout, "timeout", "", 5*time.Minute, "how long to wait for restored CRDs to be established")
+}
+
+func readBackup(file string) (*BackupManifest, map[string][]byte, error) {
+	files, err := readTarball(file)
+	if err != nil {
+		return nil, nil, withExitCode(exitValidation, errors.Wrapf(err, "%s is not a k3p backup", file))
+	}
+
+	manifestData, ok := files[backupManifestFile]
+	if !ok {
+		return nil, nil, validationError("%s is not a k3p backup: missing %s", file, backupManifestFile)
+	}
+	manifest := &BackupManifest{}
+	if err := yaml.Unmarshal(manifestData, manifest); err != nil {
+		return nil, nil, err
+	}
+	if manifest.Version != backupFormatVersion {
+		return nil, nil, validationError("unsupported backup version %q, expected %q", manifest.Version, backupFormatVersion)
+	}
+	return manifest, files, nil
+}
+
+// backupFiles returns the names of the files in a directory of the backup in a stable order
+func backupFiles(files map[string][]byte, dir string) []string {
+	var result []string
+	for name := range files {
+		if strings.HasPrefix(name, dir+"/") {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// restoreCRDs creates the CRDs of a backup that don't exist and waits for all of them to be established
+func restoreCRDs(files map[string][]byte, timeout time.Duration) error {
+	var resources []resourceRef
+	for _, name := range backupFiles(files, "crds") {
+		crd := strings.TrimSuffix(path.Base(name), ".yaml")
+		resources = append(resources, resourceRef{Kind: "CustomResourceDefinition", Name: crd})
+
+		output, err := kubectl(nil, "get", "customresourcedefinition", crd, "--ignore-not-found", "-o", "name")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(output)) != "" {
+			logrus.Infof("CRD %s already exists, keeping it", crd)
+			continue
+		}
+
+		logrus.Infof("Creating CRD %s", crd)
+		if _, err := kubectl(files[name], "create", "-f", "-"); err != nil {
+			return err
+		}
+		touched("created", "CustomResourceDefinition", "", crd)
+	}
+
+	if len(resources) == 0 {
+		return nil
+	}
+	return waitForResources(resources, timeout)
+}
+
+// restoreResources creates the custom resources of a backup and returns the ones that already exist
+func restoreResources(files map[string][]byte) ([]string, error) {
+	names := backupFiles(files, "resources")
+
+	namespaces := map[string]bool{}
+	for _, name := range names {
+		if ns := path.Base(path.Dir(name)); ns != "_cluster" {
+			namespaces[ns] = true
+		}
+	}
+	for _, ns := range sortedKeys(namespaces) {
+		if err := ensureNamespace(ns); err != nil {
+			return nil, err
+		}
+	}
+
+	var conflicts, failures []string
+	restored := 0
+	for _, name := range names {
+		resource := strings.TrimSuffix(strings.TrimPrefix(name, "resources/"), ".yaml")
+		parts := strings.SplitN(resource, "/", 3)
+		if len(parts) != 3 {
+			failures = append(failures, fmt.Sprintf("%s: not a custom resource of the backup", resource))
+			continue
+		}
+		ns := parts[1]
+		if ns == "_cluster" {
+			ns = ""
+		}
+		if _, err := kubectl(files[name], "create", "-f", "-"); err != nil {
+			if strings.Contains(err.Error(), "AlreadyExists") {
+				conflicts = append(conflicts, resource)
+				touched("exists", parts[0], ns, parts[2])
+			} else {
+				failures = append(failures, fmt.Sprintf("%s: %v", resource, err))
+			}
+			continue
+		}
+		touched("created", parts[0], ns, parts[2])
+		restored++
+	}
+
+	logrus.Infof("Restored %d custom resources", restored)
+	if len(failures) > 0 {
+		return conflicts, fmt.Errorf("failed to restore %d custom resources:\n  %s", len(failures), strings.Join(failures, "\n  "))
+	}
+	return conflicts, nil
+}
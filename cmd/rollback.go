@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollbackNamespace string
+	rollbackDryRun    bool
+	rollbackForceCRD  bool
+	rollbackTimeout   time.Duration
+	rollbackWait      bool
+)
+
+// historyEntry is a helm revision of a release with what k3p recorded about it
+type historyEntry struct {
+	helmRevision
+	Version   string `json:"version,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	CRDDigest string `json:"crdDigest,omitempty"`
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Roll back a package release and its CRDs to a previous revision",
+	Args:  usageArgs(cobra.RangeArgs(1, 2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		release, err := requireRelease(args[0], rollbackNamespace)
+		if err != nil {
+			return err
+		}
+
+		revisions, err := helmHistory(release.Name, release.Namespace)
+		if err != nil {
+			return err
+		}
+		history, err := releaseHistory(release.Name, release.Namespace)
+		if err != nil {
+			return err
+		}
+
+		current := 0
+		for _, r := range revisions {
+			if r.Revision > current {
+				current = r.Revision
+			}
+		}
+		target := current - 1
+		if len(args) == 2 {
+			if target, err = strconv.Atoi(args[1]); err != nil {
+				return usageError("invalid revision %s", args[1])
+			}
+		}
+		if target < 1 || target >= current {
+			return notFoundError("release %s has no revision %d to roll back to", release.Name, target)
+		}
+
+		if err := rollbackRelease(*release, history, target); err != nil {
+			return err
+		}
+
+		if !rollbackDryRun {
+			if revisions, err = helmHistory(release.Name, release.Namespace); err != nil {
+				return err
+			}
+			if history, err = releaseHistory(release.Name, release.Namespace); err != nil {
+				return err
+			}
+		}
+		entries := mergeHistory(revisions, history)
+		cmdResult.Data = entries
+		return printResult(func(w io.Writer) {
+			printHistory(w, entries)
+		})
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().StringVarP(&rollbackNamespace, "namespace", "n", "", "namespace of the release")
+	rollbackCmd.Flags().BoolVarP(&rollbackDryRun, "dry-run", "", false, "only show what the rollback would do")
+	rollbackCmd.Flags().BoolVarP(&rollbackForceCRD, "force-crd", "", false, "revert CRDs even if it removes stored versions or narrows the schema")
+	rollbackCmd.Flags().BoolVarP(&rollbackWait, "wait", "", false, "wait until the workloads and CRDs of the package are ready")
+	rollbackCmd.Flags().DurationVarP(&rollbackTimeout, "timeout", "", 5*time.Minute, "how long to wait with --wait, also bounds waiting for CRDs to be established")
+}
+
+// recordedRevision returns the k3p record of a helm revision, or nil if k3p didn't record it
+func recordedRevision(history []Release, revision int) *Release {
+	for i := range history {
+		if history[i].Revision == revision {
+			return &history[i]
+		}
+	}
+	return nil
+}
+
+// mergeHistory adds the package version, profile and CRDs k3p recorded to the helm history of a release
+func mergeHistory(revisions []helmRevision, history []Release) []historyEntry {
+	var result []historyEntry
+	for _, r := range revisions {
+		entry := historyEntry{helmRevision: r}
+		if record := recordedRevision(history, r.Revision); record != nil {
+			entry.Version, entry.Profile, entry.CRDDigest = record.Version, record.Profile, record.CRDDigest
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func printHistory(out io.Writer, entries []historyEntry) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tUPDATED\tSTATUS\tCHART\tVERSION\tPROFILE\tCRDS\tDESCRIPTION")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Revision, e.Updated, e.Status, e.Chart, e.Version, e.Profile, shortDigest(e.CRDDigest), e.Description)
+	}
+	w.Flush()
+}
+
+// rollbackRelease reverts the CRDs of a release if the target revision was installed with other CRDs, then rolls back the helm release
+func rollbackRelease(release Release, history []Release, target int) error {
+	record := recordedRevision(history, target)
+	if record == nil {
+		logrus.Warnf("Revision %d of release %s was not recorded by k3p, its CRDs are not reverted", target, release.Name)
+	}
+
+	revertCRDs := record != nil && record.CRDDigest != "" && record.CRDDigest != release.CRDDigest
+	if rollbackDryRun {
+		logrus.Infof("Would roll back release %s from revision %d to revision %d", release.Name, release.Revision, target)
+		if revertCRDs {
+			logrus.Infof("Would revert CRDs from %s to %s", shortDigest(release.CRDDigest), shortDigest(record.CRDDigest))
+		}
+		cmdResult.Action = "none"
+		return nil
+	}
+
+	// the CRDs installed after the rollback, waited for with --wait
+	crdDigest := release.CRDDigest
+	if revertCRDs {
+		crdDigest = record.CRDDigest
+		crdManifest, err := loadCRDManifest(release.Name, release.Namespace, record.CRDDigest)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Reverting CRDs to %s", shortDigest(record.CRDDigest))
+		if err := reconcileCRDs(crdManifest, rollbackForceCRD, rollbackTimeout); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("Rolling back release %s to revision %d", release.Name, target)
+	output, err := helm(namespaceArgs(release.Namespace, "rollback", release.Name, strconv.Itoa(target))...)
+	if err != nil {
+		return err
+	}
+	logrus.Debug(strings.TrimSpace(string(output)))
+	cmdResult.Action = "rollback"
+
+	revision, err := currentRevision(release.Name, release.Namespace)
+	if err != nil {
+		return err
+	}
+	updated := release
+	if record != nil {
+		updated = *record
+	}
+	updated.Revision = revision
+	updated.Updated = time.Now().UTC().Format(time.RFC3339)
+	if err := saveRelease(updated); err != nil {
+		return err
+	}
+	touched("rolled back", "Release", updated.Namespace, updated.Name)
+
+	if rollbackWait {
+		packageYaml := &PackageYaml{}
+		if crdDigest != "" {
+			if packageYaml.CRDManifest, err = loadCRDManifest(release.Name, release.Namespace, crdDigest); err != nil {
+				return err
+			}
+		}
+		return waitForRelease(updated.Name, updated.Namespace, packageYaml, rollbackTimeout)
+	}
+	return nil
+}
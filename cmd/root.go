@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -9,25 +12,97 @@ var (
 	cfgFile     string
 	userLicense string
 
+	// commandStarted is set once cobra parsed the command line and runs a command
+	commandStarted bool
+
 	rootCmd = &cobra.Command{
 		Use:   "k3p",
 		Short: "Package Manager for k3s",
+		Long: fmt.Sprintf("Package Manager for k3s. Failing commands exit with %d for invalid arguments, %d if a package or release "+
+			"doesn't exist, %d if fetching from the network failed, %d if helm or kubectl failed, %d if a package, file or change "+
+			"was rejected and %d otherwise.", exitUsage, exitNotFound, exitNetwork, exitCluster, exitValidation, exitFailure),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			commandStarted = true
+			cmdResult.Command = cmd.Name()
+			if err := setupLogging(); err != nil {
+				return err
+			}
+			if configErr != nil {
+				return configErr
+			}
+			if err := checkOutputFormat(); err != nil {
+				return err
+			}
+			migrateLegacyCache()
+			return lockCacheFor(cmd)
+		},
 	}
 )
 
-// Execute executes the root command.
+// Execute executes the root command. Errors are printed, with the result of the command if --output is json or yaml.
 func Execute() error {
-	return rootCmd.Execute()
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+
+	cmd, err := rootCmd.ExecuteC()
+	unlockCache()
+	if err == nil {
+		return nil
+	}
+	if !commandStarted && exitCode(err) == exitFailure {
+		// cobra failed to parse the command line
+		err = withExitCode(exitUsage, err)
+	}
+
+	logrus.Error(err)
+	if exitCode(err) == exitUsage {
+		logrus.Infof("Run '%s --help' for usage.", cmd.CommandPath())
+	}
+	if structuredOutput() && !resultPrinted {
+		cmdResult.Errors = append(cmdResult.Errors, err.Error())
+		printResult(nil)
+	}
+	return err
+}
+
+// ExitCode returns the exit code for an error returned by Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return exitCode(err)
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "", "", "config file with the package repositories, defaults to $"+configFileEnv+" or $XDG_CONFIG_HOME/k3p/config.yaml")
 
 	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(searchCmd)
 	rootCmd.AddCommand(installCmd)
+	rootCmd.AddCommand(upgradeCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(outdatedCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(deleteCmd)
 	rootCmd.AddCommand(purgeCmd)
+	rootCmd.AddCommand(imagesCmd)
+	rootCmd.AddCommand(bundleCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(postRenderCmd)
 }
 
 func initConfig() {
+	loaded, err := loadConfig(cfgFile)
+	if err != nil {
+		configErr = err
+		return
+	}
+	config = loaded
 }
@@ -1 +1,77 @@
 package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// searchResult is a package of the local index matching a search
+type searchResult struct {
+	Name             string   `json:"name"`
+	Version          string   `json:"version,omitempty"`
+	DefaultNamespace string   `json:"defaultNamespace,omitempty"`
+	Profiles         []string `json:"profiles,omitempty"`
+	DependsOn        []string `json:"dependsOn,omitempty"`
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search [keyword]",
+	Short: "Search the packages in the local cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		index, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		results := []searchResult{}
+		for _, p := range index.Packages {
+			if !matchesKeywords(p.Name, args) {
+				continue
+			}
+			result := searchResult{
+				Name:    p.Name,
+				Version: p.Version,
+			}
+			if packageYaml, err := loadPackageYaml(p.Name); err == nil {
+				result.DefaultNamespace = packageYaml.DefaultNamespace
+				for name := range packageYaml.ProfileOptions {
+					result.Profiles = append(result.Profiles, name)
+				}
+				sort.Strings(result.Profiles)
+				for _, dep := range packageYaml.DependsOn {
+					result.DependsOn = append(result.DependsOn, dep.Name)
+				}
+			}
+			results = append(results, result)
+		}
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].Name < results[j].Name
+		})
+
+		cmdResult.Data = results
+		return printResult(func(out io.Writer) {
+			w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSION\tNAMESPACE\tPROFILES\tDEPENDS ON")
+			for _, r := range results {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Version, r.DefaultNamespace,
+					strings.Join(r.Profiles, ","), strings.Join(r.DependsOn, ","))
+			}
+			w.Flush()
+		})
+	},
+}
+
+// matchesKeywords returns true if the name contains all keywords, ignoring case
+func matchesKeywords(name string, keywords []string) bool {
+	for _, k := range keywords {
+		if !strings.Contains(strings.ToLower(name), strings.ToLower(k)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	healthHealthy   = "healthy"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+
+	// exit codes of status, chosen to not collide with the exit codes of failing commands
+	exitDegraded  = 10
+	exitUnhealthy = 11
+
+	maxStatusEvents = 10
+	eventWindow     = time.Hour
+)
+
+var (
+	statusNamespace string
+
+	healthRank = map[string]int{
+		healthHealthy:   0,
+		healthDegraded:  1,
+		healthUnhealthy: 2,
+	}
+)
+
+// ReleaseStatus combines the helm status, the k3p record and the health of the resources of a release
+type ReleaseStatus struct {
+	Release    Release          `json:"release"`
+	HelmStatus string           `json:"helmStatus"`
+	Health     string           `json:"health"`
+	CRDs       []ResourceStatus `json:"crds,omitempty"`
+	Workloads  []ResourceStatus `json:"workloads,omitempty"`
+	Events     []string         `json:"events,omitempty"`
+}
+
+// ResourceStatus is the readiness of a CRD or workload
+type ResourceStatus struct {
+	resourceRef
+	Ready    bool     `json:"ready"`
+	Progress string   `json:"progress"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status and health of a package release",
+	Annotations: map[string]string{
+		cacheLockAnnotation: cacheLockNone,
+	},
+	Long: fmt.Sprintf("Show the status and health of a package release. Exits with %d if the release is degraded, e.g. pods are not ready yet, "+
+		"and with %d if it is unhealthy, e.g. the helm release failed or CRDs are not established.", exitDegraded, exitUnhealthy),
+	Args: usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		release, err := requireRelease(args[0], statusNamespace)
+		if err != nil {
+			return err
+		}
+
+		status, err := releaseStatus(*release)
+		if err != nil {
+			return err
+		}
+		cmdResult.Data = status
+		if err := printResult(func(w io.Writer) {
+			printStatus(w, status)
+		}); err != nil {
+			return err
+		}
+
+		switch status.Health {
+		case healthDegraded:
+			return withExitCode(exitDegraded, fmt.Errorf("release %s is degraded", release.Name))
+		case healthUnhealthy:
+			return withExitCode(exitUnhealthy, fmt.Errorf("release %s is unhealthy", release.Name))
+		}
+		return nil
+	},
+}
+
+func init() {
+	statusCmd.Flags().StringVarP(&statusNamespace, "namespace", "n", "", "namespace of the release")
+}
+
+func releaseStatus(release Release) (*ReleaseStatus, error) {
+	status := &ReleaseStatus{
+		Release: release,
+		Health:  healthHealthy,
+	}
+
+	output, err := helm(namespaceArgs(release.Namespace, "status", release.Name, "-o", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	helmStatus := struct {
+		Info struct {
+			Status string `json:"status"`
+		} `json:"info"`
+	}{}
+	if err := json.Unmarshal(output, &helmStatus); err != nil {
+		return nil, err
+	}
+	status.HelmStatus = helmStatus.Info.Status
+	switch {
+	case status.HelmStatus == "deployed":
+	case strings.HasPrefix(status.HelmStatus, "pending"):
+		status.degrade(healthDegraded)
+	default:
+		status.degrade(healthUnhealthy)
+	}
+
+	// check the CRDs the release installed, the cached package may have been updated since
+	var crdManifest string
+	if release.CRDDigest != "" {
+		if crdManifest, err = loadCRDManifest(release.Name, release.Namespace, release.CRDDigest); err != nil {
+			return nil, err
+		}
+	}
+	crds, err := crdResources(crdManifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range crds {
+		state, err := checkResource(ref)
+		if err != nil {
+			return nil, err
+		}
+		status.CRDs = append(status.CRDs, ResourceStatus{
+			resourceRef: ref,
+			Ready:       state.Ready,
+			Progress:    state.Progress,
+			Problems:    nonEmpty(state.Failed),
+		})
+		if !state.Ready {
+			status.degrade(healthUnhealthy)
+		}
+	}
+
+	workloads, err := releaseWorkloads(release.Name, release.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range workloads {
+		state, err := checkResource(ref)
+		if err != nil {
+			return nil, err
+		}
+		problems, err := podProblems(ref.Namespace, state.Selector)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(nonEmpty(state.Failed), problems...)
+		status.Workloads = append(status.Workloads, ResourceStatus{
+			resourceRef: ref,
+			Ready:       state.Ready,
+			Progress:    state.Progress,
+			Problems:    problems,
+		})
+		switch {
+		case state.Failed != "":
+			status.degrade(healthUnhealthy)
+		case !state.Ready || len(problems) > 0:
+			status.degrade(healthDegraded)
+		}
+	}
+
+	if status.Events, err = warningEvents(release.Namespace, workloads); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// degrade lowers the health of a release, it never improves it
+func (s *ReleaseStatus) degrade(health string) {
+	if healthRank[health] > healthRank[s.Health] {
+		s.Health = health
+	}
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// warningEvents returns the recent Warning events of the workloads of a release and the objects they own, newest first
+func warningEvents(namespace string, workloads []resourceRef) ([]string, error) {
+	if len(workloads) == 0 {
+		return nil, nil
+	}
+	if namespace == "" {
+		namespace = workloads[0].Namespace
+	}
+
+	output, err := kubectl(nil, "get", "events", "--namespace", namespace, "--field-selector", "type=Warning", "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	events := &v1.EventList{}
+	if err := json.Unmarshal(output, events); err != nil {
+		return nil, err
+	}
+
+	var recent []v1.Event
+	for _, event := range events.Items {
+		if time.Since(event.LastTimestamp.Time) > eventWindow {
+			continue
+		}
+		for _, w := range workloads {
+			if strings.HasPrefix(event.InvolvedObject.Name, w.Name) {
+				recent = append(recent, event)
+				break
+			}
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].LastTimestamp.After(recent[j].LastTimestamp.Time)
+	})
+	if len(recent) > maxStatusEvents {
+		recent = recent[:maxStatusEvents]
+	}
+
+	var result []string
+	for _, event := range recent {
+		result = append(result, fmt.Sprintf("%s %s/%s %s: %s", event.LastTimestamp.UTC().Format(time.RFC3339),
+			event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason, strings.TrimSpace(event.Message)))
+	}
+	return result, nil
+}
+
+func printStatus(out io.Writer, status *ReleaseStatus) {
+	release := status.Release
+	fmt.Fprintf(out, "Release:   %s\n", release.Name)
+	fmt.Fprintf(out, "Namespace: %s\n", release.Namespace)
+	fmt.Fprintf(out, "Package:   %s %s\n", release.Package, release.Version)
+	if release.Profile != "" {
+		fmt.Fprintf(out, "Profile:   %s\n", release.Profile)
+	}
+	if release.Revision != 0 {
+		fmt.Fprintf(out, "Revision:  %d\n", release.Revision)
+	}
+	fmt.Fprintf(out, "Status:    %s\n", status.HelmStatus)
+	fmt.Fprintf(out, "Health:    %s\n", status.Health)
+
+	resources := append(append([]ResourceStatus{}, status.CRDs...), status.Workloads...)
+	if len(resources) > 0 {
+		fmt.Fprintln(out)
+		w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAME\tREADY\tSTATUS")
+		for _, r := range resources {
+			name := r.Name
+			if r.Namespace != "" {
+				name = r.Namespace + "/" + r.Name
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", r.Kind, name, r.Ready, r.Progress)
+		}
+		w.Flush()
+	}
+
+	var problems []string
+	for _, r := range resources {
+		for _, p := range r.Problems {
+			problems = append(problems, fmt.Sprintf("%s: %s", r.resourceRef, p))
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Fprintln(out, "\nProblems:")
+		for _, p := range problems {
+			fmt.Fprintf(out, "  %s\n", p)
+		}
+	}
+
+	if len(status.Events) > 0 {
+		fmt.Fprintln(out, "\nRecent warning events:")
+		for _, e := range status.Events {
+			fmt.Fprintf(out, "  %s\n", e)
+		}
+	}
+}
@@ -1,29 +1,79 @@
 package cmd
 
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
 type Index struct {
-	Packages []struct {
-		Name    string `json:"name,omitempty"`
-		Version string `json:"version,omitempty"`
-		URL     string `json:"url,omitempty"`
-	} `json:"packages,omitempty"`
+	Packages []IndexPackage `json:"packages,omitempty"`
+}
+
+type IndexPackage struct {
+	Name    string   `json:"name,omitempty"`
+	Version string   `json:"version,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Mirrors []string `json:"mirrors,omitempty"`
+	Digest  string   `json:"digest,omitempty"`
+	// Repository is the name of the repository whose index lists the package, set by `k3p update`
+	Repository string `json:"repository,omitempty"`
 }
 
 type PackageYaml struct {
 	CRDManifest      string                 `json:"crdManifest,omitempty"`
 	RbacManifest     string                 `json:"rbacManifest,omitempty"`
 	Base             string                 `json:"base,omitempty"`
+	BaseMirrors      []string               `json:"baseMirrors,omitempty"`
+	BaseDigest       string                 `json:"baseDigest,omitempty"`
 	Url              string                 `json:"url,omitempty"`
 	Questions        []Question             `json:"questions,omitempty"`
 	ProfileOptions   map[string]Profile     `json:"profiles,omitempty"`
 	PrivateRegistry  PrivateRegistrySetting `json:"privateRegistry,omitempty"`
 	Patches          []Patch                `json:"patches,omitempty"`
-	PreDeleteCommand []string                 `json:"preDeleteCommand,omitempty"`
+	PreDeleteCommand []string               `json:"preDeleteCommand,omitempty"`
+	DependsOn        []Dependency           `json:"dependsOn,omitempty"`
+	DefaultNamespace string                 `json:"defaultNamespace,omitempty"`
+	Hooks            Hooks                  `json:"hooks,omitempty"`
+}
+
+type Hooks struct {
+	PreInstall  []Hook `json:"preInstall,omitempty"`
+	PostInstall []Hook `json:"postInstall,omitempty"`
+	PreUpgrade  []Hook `json:"preUpgrade,omitempty"`
+	PostUpgrade []Hook `json:"postUpgrade,omitempty"`
+	PreDelete   []Hook `json:"preDelete,omitempty"`
+	PostDelete  []Hook `json:"postDelete,omitempty"`
+	PrePurge    []Hook `json:"prePurge,omitempty"`
+}
+
+type Hook struct {
+	Name            string            `json:"name,omitempty"`
+	Command         []string          `json:"command,omitempty"`
+	Script          string            `json:"script,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Timeout         string            `json:"timeout,omitempty"`
+	Retries         int               `json:"retries,omitempty"`
+	ContinueOnError bool              `json:"continueOnError,omitempty"`
+	// Image runs the hook as a Job in the target namespace instead of on the local machine
+	Image string    `json:"image,omitempty"`
+	Rbac  *HookRbac `json:"rbac,omitempty"`
+}
+
+type HookRbac struct {
+	Rules        []rbacv1.PolicyRule `json:"rules,omitempty"`
+	ClusterRules []rbacv1.PolicyRule `json:"clusterRules,omitempty"`
+}
+
+type Dependency struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
 }
 
 type Patch struct {
-	Url  string `json:"url,omitempty"`
-	Path string `json:"path,omitempty"`
-	Name string `json:"name,omitempty"`
+	Url     string   `json:"url,omitempty"`
+	Mirrors []string `json:"mirrors,omitempty"`
+	Digest  string   `json:"digest,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Name    string   `json:"name,omitempty"`
 }
 
 type Question struct {
@@ -72,6 +122,9 @@ type Profile struct {
 }
 
 type PrivateRegistrySetting struct {
-	Value string `json:"registry,omitempty"`
-	Key   string `json:"key,omitempty"`
+	Value            string            `json:"registry,omitempty"`
+	Key              string            `json:"key,omitempty"`
+	RewriteImages    bool              `json:"rewriteImages,omitempty"`
+	Mirrors          map[string]string `json:"mirrors,omitempty"`
+	ImagePullSecrets []string          `json:"imagePullSecrets,omitempty"`
 }
@@ -2,122 +2,175 @@ package cmd
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
-	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"sigs.k8s.io/yaml"
 )
 
 var (
+	// LocalChartLocation is the cache of older k3p versions in $HOME
 	LocalChartLocation = ".k3s-chart-data"
 	IndexURL           = "https://storage.googleapis.com/k3s-chart-testing-2/index.yaml"
+
+	updateFromBundle string
 )
 
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update package.yaml from upsteam",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("Reading package list from %v\n", IndexURL)
-		indexData, err := httpGet(IndexURL)
-		if err != nil {
-			handleError(err)
-		}
-
-		index := &Index{}
-		if err := yaml.Unmarshal(indexData, index); err != nil {
-			handleError(err)
-		}
-
-		for _, p := range index.Packages {
-			chartBasePath := filepath.Join(os.Getenv("HOME"), LocalChartLocation, p.Name)
-			fmt.Printf("Removing old data from directory %v\n", chartBasePath)
-			if err := os.RemoveAll(chartBasePath); err != nil {
-				handleError(err)
+	Annotations: map[string]string{
+		cacheLockAnnotation: cacheLockExclusive,
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmdResult.Action = "update"
+		if updateFromBundle != "" {
+			logrus.Infof("Reading packages from bundle %v", updateFromBundle)
+			manifest, err := importBundle(updateFromBundle)
+			if err != nil {
+				return err
 			}
-			if err := os.MkdirAll(filepath.Join(chartBasePath, p.Name), 0755); err != nil {
-				handleError(err)
+			for _, p := range manifest.Packages {
+				touched("updated", "Package", "", p.Name)
 			}
+			logrus.Infof("Reading packages done")
+			return printResult(nil)
+		}
 
-			fmt.Printf("Reading package data from %v\n", p.URL)
-			packageYamlData, err := httpGet(p.URL)
+		index := &Index{}
+		for _, repo := range repositories() {
+			logrus.Infof("Reading package list of repository %s from %v", repo.Name, repo.URL)
+			indexData, err := httpGetMirrored(repo.Name, append([]string{repo.URL}, repo.Mirrors...), "")
 			if err != nil {
-				handleError(err)
+				return err
 			}
-			packageYaml := &PackageYaml{}
-			if err := yaml.Unmarshal(packageYamlData, packageYaml); err != nil {
-				handleError(err)
+			repoIndex := &Index{}
+			if err := yaml.Unmarshal(indexData, repoIndex); err != nil {
+				return withExitCode(exitValidation, errors.Wrapf(err, "failed to parse %s", repo.URL))
 			}
-			if err := ioutil.WriteFile(filepath.Join(chartBasePath, "package.yaml"), packageYamlData, 0755); err != nil {
-				handleError(err)
+			for i := range repoIndex.Packages {
+				repoIndex.Packages[i].Repository = repo.Name
 			}
+			index.Merge(repoIndex)
+		}
 
-			fmt.Printf("Reading chart data from %v\n", packageYaml.Base)
-			if err := untar(chartBasePath, packageYaml.Base); err != nil {
-				handleError(err)
+		for _, p := range index.Packages {
+			if err := validatePackageName(p.Name); err != nil {
+				return err
 			}
-
-			fmt.Printf("Applying patches...\n")
-			for _, patch := range packageYaml.Patches {
-				patchData, err := httpGet(patch.Url)
-				if err != nil {
-					handleError(err)
-				}
-
-				patchFile := filepath.Join(chartBasePath, patch.Name)
-				if err := ioutil.WriteFile(patchFile, patchData, 0755); err != nil {
-					handleError(err)
-				}
-
-				cmd := exec.Command("patch", "--no-backup-if-mismatch", patch.Path, patchFile)
-				cmd.Dir = filepath.Join(chartBasePath, "chart")
-				if patchResult, err := cmd.CombinedOutput(); err != nil {
-					fmt.Println(string(patchResult))
-					handleError(err)
-				}
+		}
+		for _, p := range index.Packages {
+			if err := updatePackage(p); err != nil {
+				return err
 			}
+			touched("updated", "Package", "", p.Name)
+		}
+		if err := saveIndex(index); err != nil {
+			return err
 		}
-		fmt.Println("Reading packages done")
+		logrus.Infof("Reading packages done")
+		return printResult(nil)
 	},
 }
 
-func handleError(err error) {
-	fmt.Println(err)
-	os.Exit(1)
+func init() {
+	updateCmd.Flags().StringVarP(&updateFromBundle, fromBundleFlag, "", "", "read packages from a bundle created by `k3p bundle` instead of the network")
 }
 
-func httpGet(url string) ([]byte, error) {
-	if strings.HasPrefix(url, "file://") {
-		return ioutil.ReadFile(strings.TrimPrefix(url, "file://"))
+// updatePackage replaces the cached package.yaml, chart and patches of a package. They are fetched into a temp dir in
+// the cache first, so the cached package is kept if fetching fails.
+func updatePackage(p IndexPackage) error {
+	if err := os.MkdirAll(cacheDir(), 0755); err != nil {
+		return err
 	}
-	resp, err := http.Get(url)
+	chartBasePath, err := ioutil.TempDir(cacheDir(), "."+p.Name+"-")
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer os.RemoveAll(chartBasePath)
+	if err := os.Chmod(chartBasePath, 0755); err != nil {
+		return err
+	}
+	if err := fetchPackage(p, chartBasePath); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	dir := packageDir(p.Name)
+	logrus.Infof("Replacing old data in directory %v", dir)
+	old := chartBasePath + ".old"
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(chartBasePath, dir); err != nil {
+		return err
 	}
-	return b, nil
+	return os.RemoveAll(old)
 }
 
-func untar(baseDir, url string) error {
-	resp, err := http.Get(url)
+// fetchPackage fetches the package.yaml, chart and patches of a package into a directory
+func fetchPackage(p IndexPackage, chartBasePath string) error {
+	logrus.Infof("Reading package data from %v", p.URL)
+	packageYamlData, err := httpGetMirrored(p.Repository, append([]string{p.URL}, p.Mirrors...), p.Digest)
+	if err != nil {
+		return err
+	}
+	packageYaml := &PackageYaml{}
+	if err := yaml.Unmarshal(packageYamlData, packageYaml); err != nil {
+		return withExitCode(exitValidation, errors.Wrapf(err, "failed to parse %s", p.URL))
+	}
+	if err := ioutil.WriteFile(filepath.Join(chartBasePath, "package.yaml"), packageYamlData, 0755); err != nil {
+		return err
+	}
+
+	logrus.Infof("Reading chart data from %v", packageYaml.Base)
+	baseData, err := httpGetMirrored(p.Repository, append([]string{packageYaml.Base}, packageYaml.BaseMirrors...), packageYaml.BaseDigest)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	if err := ioutil.WriteFile(filepath.Join(chartBasePath, baseArchive), baseData, 0644); err != nil {
+		return err
+	}
+	if err := untar(chartBasePath, bytes.NewReader(baseData)); err != nil {
+		return err
+	}
+
+	logrus.Infof("Applying patches...")
+	for _, patch := range packageYaml.Patches {
+		patchData, err := httpGetMirrored(p.Repository, append([]string{patch.Url}, patch.Mirrors...), patch.Digest)
+		if err != nil {
+			return err
+		}
+
+		patchFile := filepath.Join(chartBasePath, patch.Name)
+		if err := ioutil.WriteFile(patchFile, patchData, 0755); err != nil {
+			return err
+		}
+
+		cmd := exec.Command("patch", "--no-backup-if-mismatch", patch.Path, patchFile)
+		cmd.Dir = filepath.Join(chartBasePath, "chart")
+		start := time.Now()
+		patchResult, err := cmd.CombinedOutput()
+		logCommand(cmd.Path, cmd.Args[1:], start, err)
+		if err != nil {
+			logrus.Warn(strings.TrimSpace(string(patchResult)))
+			return errors.Wrapf(err, "applying patch %s to package %s", patch.Name, p.Name)
+		}
+	}
+	return nil
+}
 
-	gzf, err := gzip.NewReader(resp.Body)
+func untar(baseDir string, data io.Reader) error {
+	gzf, err := gzip.NewReader(data)
 	if err != nil {
 		return err
 	}
@@ -150,7 +203,6 @@ func untar(baseDir, url string) error {
 				return err
 			}
 			if err := ioutil.WriteFile(filepath.Join(baseDir, name), contents, 0755); err != nil {
-				fmt.Println("debug")
 				return err
 			}
 		}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	upgradeNamespace     string
+	upgradeProfile       string
+	upgradeCustomOptions []string
+	upgradeWait          bool
+	upgradeTimeout       time.Duration
+	upgradeForceCRD      bool
+	upgradeAll           bool
+)
+
+// upgradeResult is the outcome of upgrading one release
+type upgradeResult struct {
+	Release Release `json:"release"`
+	To      string  `json:"to"`
+	Err     error   `json:"-"`
+	Skipped bool    `json:"skipped,omitempty"`
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [release|package...]",
+	Short: "Upgrade package releases to the package version in the local cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if upgradeAll == (len(args) > 0) {
+			return usageError("either releases or packages to upgrade, or --all is required")
+		}
+
+		var releases []Release
+		if upgradeAll {
+			outdated, err := outdatedReleases()
+			if err != nil {
+				return err
+			}
+			for _, o := range outdated {
+				releases = append(releases, o.Release)
+			}
+			if len(releases) == 0 {
+				logrus.Infof("All releases are up to date")
+				cmdResult.Action = "none"
+				return printResult(nil)
+			}
+		} else {
+			selected, err := selectReleases(args, upgradeNamespace)
+			if err != nil {
+				return err
+			}
+			releases = selected
+		}
+
+		results, err := upgradeReleases(upgradeOrder(releases))
+		if err != nil {
+			return err
+		}
+		cmdResult.Action = "upgrade"
+		cmdResult.Data = results
+		for _, r := range results {
+			if r.Err != nil {
+				cmdResult.Errors = append(cmdResult.Errors, fmt.Sprintf("release %s: %v", r.Release.Name, r.Err))
+			}
+		}
+		if err := printResult(func(w io.Writer) {
+			printUpgradeResults(w, results)
+		}); err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, r := range results {
+			if r.Err != nil || r.Skipped {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d releases were not upgraded", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().BoolVarP(&upgradeAll, "all", "", false, "upgrade every release with a newer package version in the local cache")
+	upgradeCmd.Flags().StringVarP(&upgradeNamespace, "namespace", "n", "", "namespace of the release")
+	upgradeCmd.Flags().StringVarP(&upgradeProfile, "profile", "p", "", "switch the release to another profile, defaults to the profile it was installed with")
+	upgradeCmd.Flags().BoolVarP(&upgradeWait, "wait", "", false, "wait until the workloads and CRDs of the package are ready")
+	upgradeCmd.Flags().DurationVarP(&upgradeTimeout, "timeout", "", 5*time.Minute, "how long to wait with --wait, also bounds waiting for CRDs to be established")
+	upgradeCmd.Flags().BoolVarP(&upgradeForceCRD, "force-crd", "", false, "apply CRD changes even if they remove stored versions or narrow the schema")
+	upgradeCmd.Flags().StringArrayVarP(&upgradeCustomOptions, "custom-options", "", nil, "pass custom helm options, defaults to the ones the release was installed with")
+}
+
+// selectReleases looks up each name as a release, falling back to all releases of a package with that name
+func selectReleases(names []string, namespace string) ([]Release, error) {
+	all, err := listReleases()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Release
+	selected := map[string]bool{}
+	for _, name := range names {
+		release, err := findRelease(name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		matches := []Release{}
+		if release != nil {
+			matches = append(matches, *release)
+		} else {
+			for _, r := range all {
+				if r.Package == name && (namespace == "" || r.Namespace == namespace) {
+					matches = append(matches, r)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return nil, notFoundError("release or package %s was not installed by k3p", name)
+		}
+		for _, r := range matches {
+			if !selected[releaseKey(r)] {
+				selected[releaseKey(r)] = true
+				result = append(result, r)
+			}
+		}
+	}
+	return result, nil
+}
+
+// upgradeOrder sorts releases so that the releases of a package come after the releases of the packages it depends on
+func upgradeOrder(releases []Release) []Release {
+	byPackage := map[string][]Release{}
+	var packages []string
+	for _, r := range releases {
+		if _, ok := byPackage[r.Package]; !ok {
+			packages = append(packages, r.Package)
+		}
+		byPackage[r.Package] = append(byPackage[r.Package], r)
+	}
+
+	var result []Release
+	state := map[string]int{}
+	var visit func(packageName string)
+	visit = func(packageName string) {
+		if state[packageName] != unvisited {
+			return
+		}
+		state[packageName] = visiting
+		if packageYaml, err := loadPackageYaml(packageName); err == nil {
+			for _, dep := range packageYaml.DependsOn {
+				if _, ok := byPackage[dep.Name]; ok {
+					visit(dep.Name)
+				}
+			}
+		}
+		state[packageName] = visited
+		result = append(result, byPackage[packageName]...)
+	}
+	for _, p := range packages {
+		visit(p)
+	}
+	return result
+}
+
+// upgradeReleases upgrades each release with its recorded profile, answers and values. Releases of packages that
+// depend on a package that failed to upgrade are skipped.
+func upgradeReleases(releases []Release) ([]upgradeResult, error) {
+	index, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	failedPackages := map[string]bool{}
+	var results []upgradeResult
+	for _, release := range releases {
+		available, _ := index.Find(release.Package)
+		result := upgradeResult{
+			Release: release,
+			To:      available.Version,
+		}
+
+		if packageYaml, err := loadPackageYaml(release.Package); err == nil {
+			for _, dep := range packageYaml.DependsOn {
+				if failedPackages[dep.Name] {
+					result.Skipped = true
+				}
+			}
+		}
+
+		if !result.Skipped {
+			logrus.Infof("Upgrading release %s of package %s", release.Name, release.Package)
+			result.Err = upgradeRelease(release)
+			if result.Err != nil {
+				logrus.Warnf("Upgrading release %s failed: %v", release.Name, result.Err)
+			}
+		}
+		if result.Err != nil || result.Skipped {
+			failedPackages[release.Package] = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func upgradeRelease(release Release) error {
+	releaseProfile := release.Profile
+	if upgradeProfile != "" {
+		releaseProfile = upgradeProfile
+	}
+	customOptions := release.CustomOptions
+	if len(upgradeCustomOptions) > 0 {
+		customOptions = upgradeCustomOptions
+	}
+
+	return installPackage(release.Package, installOptions{
+		ReleaseName:      release.Name,
+		Namespace:        release.Namespace,
+		Profile:          releaseProfile,
+		Answers:          release.Answers,
+		Values:           release.Values,
+		CustomOptions:    customOptions,
+		PrivateRegistry:  release.PrivateRegistry,
+		RegistryMirrors:  release.RegistryMirrors,
+		ImagePullSecrets: release.ImagePullSecrets,
+		Wait:             upgradeWait,
+		Timeout:          upgradeTimeout,
+		ForceCRD:         upgradeForceCRD,
+	})
+}
+
+func printUpgradeResults(out io.Writer, results []upgradeResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "RELEASE\tNAMESPACE\tPACKAGE\tFROM\tTO\tRESULT")
+	for _, r := range results {
+		outcome := "upgraded"
+		switch {
+		case r.Skipped:
+			outcome = "skipped, a dependency failed"
+		case r.Err != nil:
+			outcome = "failed: " + strings.SplitN(r.Err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Release.Name, r.Release.Namespace, r.Release.Package, r.Release.Version, r.To, outcome)
+	}
+	w.Flush()
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+type version struct {
+	parts      [3]int
+	prerelease string
+}
+
+func parseVersion(v string) (version, error) {
+	result := version{}
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		result.prerelease = s[i+1:]
+		s = s[:i]
+		for _, id := range strings.Split(result.prerelease, ".") {
+			if id == "" {
+				return result, validationError("invalid version %q", v)
+			}
+		}
+	}
+
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 || s == "" {
+		return result, validationError("invalid version %q", v)
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return result, validationError("invalid version %q", v)
+		}
+		result.parts[i] = n
+	}
+	return result, nil
+}
+
+func (v version) compare(other version) int {
+	for i := range v.parts {
+		if v.parts[i] != other.parts[i] {
+			if v.parts[i] < other.parts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.prerelease == other.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	}
+	return comparePrerelease(v.prerelease, other.prerelease)
+}
+
+// comparePrerelease compares the dot separated identifiers of prereleases as semver does: numeric identifiers by
+// value and lower than alphanumeric ones, which are compared as strings. A prefix of the identifiers of a prerelease
+// is lower than the prerelease.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdentifiers(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && !bNumeric:
+		return -1
+	case !aNumeric && bNumeric:
+		return 1
+	case aNumeric && len(a) != len(b):
+		// numbers without leading zeros, the longer one is larger
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.compare(vb), nil
+}
+
+// versionSatisfies checks a version against a comma separated list of constraints such as ">=1.2, <2",
+// "^1.2", "~1.2.3", "1.2.x" or "1.2.3". An empty constraint matches every version.
+func versionSatisfies(v, constraint string) (bool, error) {
+	if strings.TrimSpace(constraint) == "" || strings.TrimSpace(constraint) == "*" {
+		return true, nil
+	}
+	actual, err := parseVersion(v)
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range strings.Split(constraint, ",") {
+		ok, err := satisfies(actual, strings.TrimSpace(c))
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func satisfies(actual version, constraint string) (bool, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(constraint, prefix) {
+			op = prefix
+			break
+		}
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(constraint, op))
+
+	// 1.2.x matches every patch release of 1.2 and 1.x every release of 1
+	fields := strings.Split(strings.TrimPrefix(target, "v"), ".")
+	wildcard := len(fields)
+	for i, f := range fields {
+		if f == "x" || f == "X" || f == "*" {
+			wildcard = i
+			break
+		}
+	}
+	if wildcard < len(fields) {
+		if op != "" && op != "=" {
+			return false, validationError("invalid version constraint %q", constraint)
+		}
+		target = strings.Join(fields[:wildcard], ".")
+		op = "~"
+		if wildcard == 1 {
+			op = "^"
+		}
+		if target == "" {
+			return true, nil
+		}
+	}
+
+	expected, err := parseVersion(target)
+	if err != nil {
+		return false, validationError("invalid version constraint %q", constraint)
+	}
+	cmp := actual.compare(expected)
+
+	switch op {
+	case "", "=":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case "^":
+		// the left-most non-zero part of the given parts is fixed
+		if cmp < 0 {
+			return false, nil
+		}
+		given := len(strings.Split(strings.TrimPrefix(target, "v"), "."))
+		if expected.parts[0] != 0 || given == 1 {
+			return actual.parts[0] == expected.parts[0], nil
+		}
+		if expected.parts[1] != 0 || given == 2 {
+			return actual.parts[0] == 0 && actual.parts[1] == expected.parts[1], nil
+		}
+		return actual.parts == expected.parts, nil
+	case "~":
+		if cmp < 0 {
+			return false, nil
+		}
+		if len(strings.Split(target, ".")) == 1 {
+			return actual.parts[0] == expected.parts[0], nil
+		}
+		return actual.parts[0] == expected.parts[0] && actual.parts[1] == expected.parts[1], nil
+	}
+	return false, validationError("invalid version constraint %q", constraint)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-rc.10", "1.0.0-rc.2", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-rc.99999999999999999999", "1.0.0-rc.100000000000000000000", -1},
+	}
+	for _, test := range tests {
+		actual, err := compareVersions(test.a, test.b)
+		if err != nil {
+			t.Errorf("comparing %s and %s: %v", test.a, test.b, err)
+		} else if actual != test.expected {
+			t.Errorf("comparing %s and %s: expected %d, got %d", test.a, test.b, test.expected, actual)
+		}
+	}
+}
+
+func TestParseVersionInvalid(t *testing.T) {
+	for _, v := range []string{"", "v", "1.2.3.4", "1.a.0", "1.0.0-", "1.0.0-rc..1", "latest"} {
+		if _, err := parseVersion(v); err == nil {
+			t.Errorf("expected %q to be invalid", v)
+		}
+	}
+}
+
+func TestVersionSatisfies(t *testing.T) {
+	tests := []struct {
+		version, constraint string
+		expected            bool
+	}{
+		{"1.2.3", "", true},
+		{"1.2.3", "*", true},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "=1.2.3", true},
+		{"1.2.4", "1.2.3", false},
+		{"1.2.4", "!=1.2.3", true},
+		{"1.2.3", ">1.2.3", false},
+		{"1.2.3", ">=1.2.3", true},
+		{"1.2.3", "<1.2.3", false},
+		{"1.2.3", "<=1.2.3", true},
+		{"1.5.0", ">=1.2, <2", true},
+		{"2.0.0", ">=1.2, <2", false},
+		{"2.0.0-rc.1", "<2", true},
+		{"1.0.0-rc.10", ">1.0.0-rc.2", true},
+		{"1.0.0-rc.2", ">=1.0.0-rc.10", false},
+		{"1.9.0", "^1.2", true},
+		{"2.0.0", "^1.2", false},
+		{"1.1.0", "^1.2", false},
+		{"0.2.5", "^0.2.3", true},
+		{"0.3.0", "^0.2.3", false},
+		{"0.0.3", "^0.0.3", true},
+		{"0.0.4", "^0.0.3", false},
+		{"0.5.0", "^0", true},
+		{"0.0.5", "^0.0", true},
+		{"0.1.0", "^0.0", false},
+		{"1.2.9", "~1.2.3", true},
+		{"1.3.0", "~1.2.3", false},
+		{"1.9.0", "~1", true},
+		{"1.2.7", "1.2.x", true},
+		{"1.3.0", "1.2.x", false},
+		{"1.9.0", "1.x", true},
+		{"0.9.0", "0.x", true},
+		{"1.0.0", "0.x", false},
+		{"3.0.0", "x", true},
+	}
+	for _, test := range tests {
+		actual, err := versionSatisfies(test.version, test.constraint)
+		if err != nil {
+			t.Errorf("%s against %q: %v", test.version, test.constraint, err)
+		} else if actual != test.expected {
+			t.Errorf("%s against %q: expected %v, got %v", test.version, test.constraint, test.expected, actual)
+		}
+	}
+}
+
+func TestVersionSatisfiesInvalid(t *testing.T) {
+	for _, constraint := range []string{">1.x", "^a.b", "~1.2.3.4", ">=1.0.0-"} {
+		if _, err := versionSatisfies("1.2.3", constraint); err == nil {
+			t.Errorf("expected constraint %q to be invalid", constraint)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3p/pkg/registry"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	waitableKinds = map[string]bool{
+		"Deployment":  true,
+		"StatefulSet": true,
+		"DaemonSet":   true,
+		"Job":         true,
+	}
+)
+
+type resourceRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (r resourceRef) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s %s", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// resourceState is the readiness of a single workload or CRD
+type resourceState struct {
+	Ready    bool
+	Progress string
+	// Selector selects the pods of a workload
+	Selector string
+	// Failed is set if the resource can't become ready without intervention
+	Failed string
+}
+
+// manifestResources returns the objects of a multi-document manifest, defaulting their namespace
+func manifestResources(manifest []byte, namespace string) ([]resourceRef, error) {
+	var result []resourceRef
+	for _, doc := range registry.SplitDocuments(manifest) {
+		obj := struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		if obj.Kind == "" || obj.Metadata.Name == "" {
+			continue
+		}
+		ref := resourceRef{
+			Kind:      obj.Kind,
+			Namespace: obj.Metadata.Namespace,
+			Name:      obj.Metadata.Name,
+		}
+		if ref.Namespace == "" && obj.Kind != "CustomResourceDefinition" {
+			ref.Namespace = namespace
+		}
+		result = append(result, ref)
+	}
+	return result, nil
+}
+
+// releaseWorkloads returns the deployments, statefulsets, daemonsets and jobs of a helm release
+func releaseWorkloads(release, namespace string) ([]resourceRef, error) {
+	manifest, err := helm(namespaceArgs(namespace, "get", "manifest", release)...)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		if namespace, err = currentNamespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	resources, err := manifestResources(manifest, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var result []resourceRef
+	for _, r := range resources {
+		if waitableKinds[r.Kind] {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// crdResources returns the CRDs defined by a CRD manifest
+func crdResources(crdManifest string) ([]resourceRef, error) {
+	resources, err := manifestResources([]byte(crdManifest), "")
+	if err != nil {
+		return nil, err
+	}
+	var result []resourceRef
+	for _, r := range resources {
+		if r.Kind == "CustomResourceDefinition" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func getResource(ref resourceRef, obj interface{}) error {
+	args := []string{"get", ref.Kind, ref.Name, "-o", "json"}
+	if ref.Namespace != "" {
+		args = append(args, "--namespace", ref.Namespace)
+	}
+	output, err := kubectl(nil, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(output, obj)
+}
+
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
+
+func checkResource(ref resourceRef) (resourceState, error) {
+	switch ref.Kind {
+	case "Deployment":
+		d := &appsv1.Deployment{}
+		if err := getResource(ref, d); err != nil {
+			return resourceState{}, err
+		}
+		want := replicas(d.Spec.Replicas)
+		state := resourceState{
+			Ready: d.Status.ObservedGeneration >= d.Generation &&
+				d.Status.UpdatedReplicas == want && d.Status.AvailableReplicas == want,
+			Progress: fmt.Sprintf("%d/%d available", d.Status.AvailableReplicas, want),
+			Selector: metav1.FormatLabelSelector(d.Spec.Selector),
+		}
+		for _, cond := range d.Status.Conditions {
+			if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+				state.Failed = cond.Message
+			}
+		}
+		return state, nil
+	case "StatefulSet":
+		s := &appsv1.StatefulSet{}
+		if err := getResource(ref, s); err != nil {
+			return resourceState{}, err
+		}
+		want := replicas(s.Spec.Replicas)
+		updated := s.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType || s.Status.UpdateRevision == s.Status.CurrentRevision
+		return resourceState{
+			Ready: s.Status.ObservedGeneration >= s.Generation &&
+				s.Status.ReadyReplicas == want && updated,
+			Progress: fmt.Sprintf("%d/%d ready", s.Status.ReadyReplicas, want),
+			Selector: metav1.FormatLabelSelector(s.Spec.Selector),
+		}, nil
+	case "DaemonSet":
+		d := &appsv1.DaemonSet{}
+		if err := getResource(ref, d); err != nil {
+			return resourceState{}, err
+		}
+		want := d.Status.DesiredNumberScheduled
+		return resourceState{
+			Ready: d.Status.ObservedGeneration >= d.Generation &&
+				d.Status.NumberReady == want && d.Status.UpdatedNumberScheduled == want,
+			Progress: fmt.Sprintf("%d/%d ready", d.Status.NumberReady, want),
+			Selector: metav1.FormatLabelSelector(d.Spec.Selector),
+		}, nil
+	case "Job":
+		j := &batchv1.Job{}
+		if err := getResource(ref, j); err != nil {
+			return resourceState{}, err
+		}
+		want := replicas(j.Spec.Completions)
+		state := resourceState{
+			Ready:    j.Status.Succeeded >= want,
+			Progress: fmt.Sprintf("%d/%d completed", j.Status.Succeeded, want),
+			Selector: metav1.FormatLabelSelector(j.Spec.Selector),
+		}
+		for _, cond := range j.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Status == v1.ConditionTrue {
+				state.Failed = fmt.Sprintf("%s: %s", cond.Reason, cond.Message)
+			}
+		}
+		return state, nil
+	case "CustomResourceDefinition":
+		crd := struct {
+			Status struct {
+				Conditions []struct {
+					Type    string `json:"type"`
+					Status  string `json:"status"`
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"conditions"`
+			} `json:"status"`
+		}{}
+		if err := getResource(ref, &crd); err != nil {
+			return resourceState{}, err
+		}
+		state := resourceState{Progress: "not established"}
+		for _, cond := range crd.Status.Conditions {
+			if cond.Type == "Established" && cond.Status == "True" {
+				state.Ready = true
+				state.Progress = "established"
+			}
+			if cond.Type == "NamesAccepted" && cond.Status == "False" {
+				state.Failed = fmt.Sprintf("%s: %s", cond.Reason, cond.Message)
+			}
+		}
+		return state, nil
+	}
+	return resourceState{Ready: true}, nil
+}
+
+// podProblems explains why the pods of a workload are not ready, e.g. ImagePullBackOff or an unschedulable pod
+func podProblems(namespace, selector string) ([]string, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	output, err := kubectl(nil, "get", "pods", "--namespace", namespace, "-l", selector, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	pods := &v1.PodList{}
+	if err := json.Unmarshal(output, pods); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, pod := range pods.Items {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == v1.PodScheduled && cond.Status == v1.ConditionFalse {
+				result = append(result, fmt.Sprintf("pod %s is %s: %s", pod.Name, cond.Reason, cond.Message))
+			}
+		}
+		statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason != "" && status.State.Waiting.Reason != "ContainerCreating" && status.State.Waiting.Reason != "PodInitializing" {
+				result = append(result, fmt.Sprintf("pod %s container %s is %s: %s", pod.Name, status.Name, status.State.Waiting.Reason, status.State.Waiting.Message))
+			}
+		}
+	}
+	return result, nil
+}
+
+// waitForResources waits until all resources are ready, printing progress whenever it changes.
+// On timeout the error lists the resources that are not ready and why.
+func waitForResources(resources []resourceRef, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	progress := map[resourceRef]string{}
+
+	for {
+		var pending []resourceRef
+		states := map[resourceRef]resourceState{}
+		for _, ref := range resources {
+			state, err := checkResource(ref)
+			if err != nil {
+				return err
+			}
+			states[ref] = state
+			if state.Progress != progress[ref] {
+				logrus.Infof("%s: %s", ref, state.Progress)
+				progress[ref] = state.Progress
+			}
+			if state.Failed != "" {
+				return clusterError(fmt.Errorf("%s failed: %s", ref, state.Failed))
+			}
+			if !state.Ready {
+				pending = append(pending, ref)
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			var reasons []string
+			for _, ref := range pending {
+				problems, err := podProblems(ref.Namespace, states[ref].Selector)
+				if err != nil {
+					return err
+				}
+				reason := fmt.Sprintf("%s (%s)", ref, states[ref].Progress)
+				if len(problems) > 0 {
+					reason += ": " + strings.Join(problems, "; ")
+				}
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			return clusterError(fmt.Errorf("timed out after %v waiting for:\n  %s", timeout, strings.Join(reasons, "\n  ")))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// waitForRelease waits for the CRDs of a package and the workloads of its release to become ready
+func waitForRelease(release, namespace string, packageYaml *PackageYaml, timeout time.Duration) error {
+	resources, err := crdResources(packageYaml.CRDManifest)
+	if err != nil {
+		return err
+	}
+	workloads, err := releaseWorkloads(release, namespace)
+	if err != nil {
+		return err
+	}
+	resources = append(resources, workloads...)
+
+	logrus.Infof("Waiting up to %v for %d resources of release %s to be ready", timeout, len(resources), release)
+	return waitForResources(resources, timeout)
+}
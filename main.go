@@ -1,7 +1,13 @@
 package main
 
-import "github.com/rancher/k3p/cmd"
+import (
+	"os"
+
+	"github.com/rancher/k3p/cmd"
+)
 
 func main() {
-    cmd.Execute()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(cmd.ExitCode(err))
+	}
 }
@@ -79,8 +79,11 @@ type RbacSetting struct {
 }
 
 type PrivateRegistrySetting struct {
-	Value string `json:"registry,omitempty"`
-	Key   string `json:"key,omitempty"`
+	Value            string            `json:"registry,omitempty"`
+	Key              string            `json:"key,omitempty"`
+	RewriteImages    bool              `json:"rewriteImages,omitempty"`
+	Mirrors          map[string]string `json:"mirrors,omitempty"`
+	ImagePullSecrets []string          `json:"imagePullSecrets,omitempty"`
 }
 
 type ChartStatus struct {
@@ -88,6 +88,7 @@ func (in *ChartList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartSpec) DeepCopyInto(out *ChartSpec) {
 	*out = *in
+	in.RbacSetting.DeepCopyInto(&out.RbacSetting)
 	if in.Questions != nil {
 		in, out := &in.Questions, &out.Questions
 		*out = make([]Question, len(*in))
@@ -95,13 +96,14 @@ func (in *ChartSpec) DeepCopyInto(out *ChartSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	if in.Profile != nil {
-		in, out := &in.Profile, &out.Profile
-		*out = make(map[string]string, len(*in))
+	if in.ProfileOptions != nil {
+		in, out := &in.ProfileOptions, &out.ProfileOptions
+		*out = make(map[string]Profile, len(*in))
 		for key, val := range *in {
 			(*out)[key] = val
 		}
 	}
+	in.PrivateRegistry.DeepCopyInto(&out.PrivateRegistry)
 	if in.Patches != nil {
 		in, out := &in.Patches, &out.Patches
 		*out = make(map[string]string, len(*in))
@@ -109,6 +111,13 @@ func (in *ChartSpec) DeepCopyInto(out *ChartSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ValueOverride != nil {
+		in, out := &in.ValueOverride, &out.ValueOverride
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -138,6 +147,50 @@ func (in *ChartStatus) DeepCopy() *ChartStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrivateRegistrySetting) DeepCopyInto(out *PrivateRegistrySetting) {
+	*out = *in
+	if in.Mirrors != nil {
+		in, out := &in.Mirrors, &out.Mirrors
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrivateRegistrySetting.
+func (in *PrivateRegistrySetting) DeepCopy() *PrivateRegistrySetting {
+	if in == nil {
+		return nil
+	}
+	out := new(PrivateRegistrySetting)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Profile) DeepCopyInto(out *Profile) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Profile.
+func (in *Profile) DeepCopy() *Profile {
+	if in == nil {
+		return nil
+	}
+	out := new(Profile)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Question) DeepCopyInto(out *Question) {
 	*out = *in
@@ -166,6 +219,24 @@ func (in *Question) DeepCopy() *Question {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RbacSetting) DeepCopyInto(out *RbacSetting) {
+	*out = *in
+	in.Roles.DeepCopyInto(&out.Roles)
+	in.ClusterRoles.DeepCopyInto(&out.ClusterRoles)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RbacSetting.
+func (in *RbacSetting) DeepCopy() *RbacSetting {
+	if in == nil {
+		return nil
+	}
+	out := new(RbacSetting)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubQuestion) DeepCopyInto(out *SubQuestion) {
 	*out = *in
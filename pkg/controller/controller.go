@@ -2,21 +2,33 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/rancher/k3p/pkg/apis/helm.k3s.io/v1alpha1"
 	helmcontroller "github.com/rancher/k3p/pkg/generated/controllers/helm.k3s.io/v1alpha1"
+	"github.com/rancher/k3p/pkg/rbac"
+	"github.com/rancher/k3p/pkg/registry"
 	"github.com/rancher/k3p/types"
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
-	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 )
 
 var (
-	systemNamespace = "kube-system"
+	helmImage = "strongmonkey1992/helm-install"
+	// valuesDir is where the values of the selected profile are mounted in the helm job
+	valuesDir    = "/tmp/values"
+	valuesVolume = "values"
+	// k3pImage ships k3p and its post-render wrapper, see package/Dockerfile
+	k3pImage = "rancher/k3p"
+	// postRendererDir is where an init container copies k3p and the wrapper for the helm job
+	postRendererDir = "/k3p/bin"
+	postRenderer    = postRendererDir + "/k3p-post-render"
+	// registryConfigDir is where the registry config of a chart is mounted, k3p-post-render reads config.json in it
+	registryConfigDir = "/k3p/registry"
 )
 
 func Register(ctx context.Context, rContext *types.Context, insecure bool) error {
@@ -24,11 +36,12 @@ func Register(ctx context.Context, rContext *types.Context, insecure bool) error
 		WithCacheTypes(
 			rContext.Batch.Batch().V1().Job(),
 			rContext.Core.Core().V1().ServiceAccount(),
+			rContext.Core.Core().V1().ConfigMap(),
 			rContext.RBAC.Rbac().V1().Role(),
 			rContext.RBAC.Rbac().V1().ClusterRole(),
 			rContext.RBAC.Rbac().V1().RoleBinding(),
 			rContext.RBAC.Rbac().V1().ClusterRoleBinding(),
-			).
+		).
 		WithPatcher(batch.SchemeGroupVersion.WithKind("Job"), func(namespace, name string, pt k8stypes.PatchType, data []byte) (runtime.Object, error) {
 			err := rContext.Batch.Batch().V1().Job().Delete(namespace, name, &metav1.DeleteOptions{})
 			if err == nil {
@@ -59,15 +72,7 @@ type handler struct {
 }
 
 func serviceAccountName(obj *v1alpha1.Chart) string {
-	return fmt.Sprintf("%s-sa-install", obj.Name)
-}
-
-func roleName(obj *v1alpha1.Chart) string {
-	return fmt.Sprintf("%s-role-install", obj.Name)
-}
-
-func clusterroleName(obj *v1alpha1.Chart) string {
-	return fmt.Sprintf("%s-clusterrole-install", obj.Name)
+	return rbac.ServiceAccountName(obj.Name)
 }
 
 func (h handler) generate(obj *v1alpha1.Chart, status v1alpha1.ChartStatus) ([]runtime.Object, v1alpha1.ChartStatus, error) {
@@ -75,18 +80,23 @@ func (h handler) generate(obj *v1alpha1.Chart, status v1alpha1.ChartStatus) ([]r
 
 	result = append(result, h.generateRbacRoles(obj)...)
 	result = append(result, h.generateServiceAccount(obj)...)
+	result = append(result, h.generateValuesConfigMap(obj))
 
+	registryObjects, err := h.generateRegistryConfig(obj)
+	if err != nil {
+		return nil, status, err
+	}
+	result = append(result, registryObjects...)
+
+	job := h.generateJob(obj)
+	result = append(result, job)
+	status.JobName = job.Name
 
+	return result, status, nil
 }
 
 func (h handler) generateServiceAccount(obj *v1alpha1.Chart) []runtime.Object {
-	sa := &v1.ServiceAccount{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: 	serviceAccountName(obj),
-			Namespace: obj.Namespace,
-		},
-	}
-	return []runtime.Object{sa}
+	return []runtime.Object{rbac.ServiceAccount(obj.Name, obj.Namespace)}
 }
 
 // if controller is set to insecure mode, also create (cluster)roles and (cluster)rolebindings
@@ -94,61 +104,116 @@ func (h handler) generateRbacRoles(obj *v1alpha1.Chart) []runtime.Object {
 	var result []runtime.Object
 
 	if h.insecure {
-		role := obj.Spec.RbacSetting.Roles
-		role.Name = fmt.Sprintf("%s-role-install", obj.Name)
+		result = append(result,
+			rbac.Role(obj.Name, obj.Namespace, obj.Spec.RbacSetting.Roles),
+			rbac.ClusterRole(obj.Name, obj.Namespace, obj.Spec.RbacSetting.ClusterRoles))
+	}
 
-		clusterrole := obj.Spec.RbacSetting.ClusterRoles
-		clusterrole.Name = fmt.Sprintf("%s-clusterrole-install", obj.Name)
+	result = append(result,
+		rbac.RoleBinding(obj.Name, obj.Namespace),
+		rbac.ClusterRoleBinding(obj.Name, obj.Namespace))
 
-		result = append(result, &role, &clusterrole)
-	}
+	return result
+}
 
-	rolebinding := &rbacv1.RoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-rolebinding-install"),
-			Namespace: obj.Namespace,
-		},
-		RoleRef: rbacv1.RoleRef{
-			APIGroup: rbacv1.GroupName,
-			Kind:     "Role",
-			Name:     roleName(obj),
-		},
-		Subjects: []rbacv1.Subject{
-			{
-				APIGroup:  v1.GroupName,
-				Name:      serviceAccountName(obj),
+// generateRegistryConfig returns the ConfigMap with the registry config the post-renderer of the helm job reads
+func (h handler) generateRegistryConfig(obj *v1alpha1.Chart) ([]runtime.Object, error) {
+	config := registryConfig(obj)
+	if !config.Enabled() {
+		return nil, nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return []runtime.Object{
+		&v1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      registryConfigName(obj),
 				Namespace: obj.Namespace,
 			},
+			Data: map[string]string{
+				"config.json": string(data),
+			},
 		},
-	}
+	}, nil
+}
 
-	clusterrolebinding := &rbacv1.ClusterRoleBinding{
+func registryConfigName(obj *v1alpha1.Chart) string {
+	return fmt.Sprintf("%s-k3p-registry", obj.Name)
+}
+
+// generateValuesConfigMap returns the ConfigMap with the values of the selected profile, or of the default profile
+func (h handler) generateValuesConfigMap(obj *v1alpha1.Chart) runtime.Object {
+	values := ""
+	if profile, ok := obj.Spec.ProfileOptions[obj.Spec.Profile]; ok {
+		values = profile.ValueYaml
+	} else if obj.Spec.Profile == "" {
+		for _, profile := range obj.Spec.ProfileOptions {
+			if profile.Default {
+				values = profile.ValueYaml
+			}
+		}
+	}
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-clusterrolebinding-install"),
+			Name:      valuesConfigName(obj),
 			Namespace: obj.Namespace,
 		},
-		RoleRef: rbacv1.RoleRef{
-			APIGroup: rbacv1.GroupName,
-			Kind:     "ClusterRole",
-			Name:     clusterroleName(obj),
-		},
-		Subjects: []rbacv1.Subject{
-			{
-				APIGroup:  v1.GroupName,
-				Name:      serviceAccountName(obj),
-				Namespace: obj.Namespace,
-			},
+		Data: map[string]string{
+			"values.yaml": values,
 		},
 	}
+}
+
+func valuesConfigName(obj *v1alpha1.Chart) string {
+	return fmt.Sprintf("%s-values", obj.Name)
+}
 
-	result = append(result, rolebinding, clusterrolebinding)
+// addPostRenderer lets the helm job run k3p-post-render: an init container copies k3p and the wrapper from the k3p
+// image and the registry config is mounted from its ConfigMap
+func addPostRenderer(obj *v1alpha1.Chart, spec *v1.PodSpec) {
+	if !registryConfig(obj).Enabled() {
+		return
+	}
+	binVolume := v1.VolumeMount{Name: "k3p-bin", MountPath: postRendererDir}
+	configVolume := v1.VolumeMount{Name: "k3p-registry", MountPath: registryConfigDir, ReadOnly: true}
 
-	return result
+	spec.Volumes = append(spec.Volumes,
+		v1.Volume{
+			Name:         binVolume.Name,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		},
+		v1.Volume{
+			Name: configVolume.Name,
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: registryConfigName(obj)},
+				},
+			},
+		})
+	spec.InitContainers = append(spec.InitContainers, v1.Container{
+		Name:         "k3p-post-render",
+		Image:        k3pImage,
+		Command:      []string{"cp", "/usr/bin/k3p", "/usr/bin/k3p-post-render", postRendererDir},
+		VolumeMounts: []v1.VolumeMount{binVolume},
+	})
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, binVolume, configVolume)
+	}
 }
 
 func (h handler) generateValues(obj *v1alpha1.Chart) []string {
 	var answerArgs []string
-	answerArgs = []string{"--value", "/tmp/values/values.yaml"}
+	answerArgs = []string{"--values", valuesDir + "/values.yaml"}
 	for k, v := range obj.Spec.ValueOverride {
 		answerArgs = append(answerArgs, "--set", fmt.Sprintf("%s=%s", k, v))
 	}
@@ -157,48 +222,82 @@ func (h handler) generateValues(obj *v1alpha1.Chart) []string {
 		answerArgs = append(answerArgs, "--set", fmt.Sprintf("%s=%s", obj.Spec.PrivateRegistry.Key, obj.Spec.PrivateRegistry.Value))
 	}
 
+	if registryConfig(obj).Enabled() {
+		answerArgs = append(answerArgs, "--post-renderer", postRenderer)
+	}
+
 	return answerArgs
 }
 
-func (h handler) generateJob(obj *v1alpha1.Chart) error {
-	volumeName := "chart-dir"
-	mountPath := "/tmp/charts"
+func registryConfig(obj *v1alpha1.Chart) registry.Config {
+	setting := obj.Spec.PrivateRegistry
+	config := registry.Config{
+		Mirrors:          setting.Mirrors,
+		ImagePullSecrets: setting.ImagePullSecrets,
+	}
+	if setting.RewriteImages {
+		config.Registry = setting.Value
+	}
+	return config
+}
 
-	job := batch.Job{
+func (h handler) generateJob(obj *v1alpha1.Chart) *batch.Job {
+	args := []string{
+		"helm",
+		"upgrade",
+		"--install",
+		obj.Name,
+		obj.Spec.Base,
+		"--namespace",
+		obj.Namespace,
+	}
+	args = append(args, h.generateValues(obj)...)
+
+	job := &batch.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batch.SchemeGroupVersion.String(),
+			Kind:       "Job",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: obj.Name + "-",
-			Namespace:    systemNamespace,
+			Name:      jobName(obj),
+			Namespace: obj.Namespace,
 		},
 		Spec: batch.JobSpec{
 			Template: v1.PodTemplateSpec{
 				Spec: v1.PodSpec{
 					ServiceAccountName: serviceAccountName(obj),
+					RestartPolicy:      v1.RestartPolicyOnFailure,
 					Volumes: []v1.Volume{
 						{
-							Name: volumeName,
+							Name: valuesVolume,
 							VolumeSource: v1.VolumeSource{
-								EmptyDir: &v1.EmptyDirVolumeSource{},
+								ConfigMap: &v1.ConfigMapVolumeSource{
+									LocalObjectReference: v1.LocalObjectReference{Name: valuesConfigName(obj)},
+								},
 							},
 						},
 					},
 					Containers: []v1.Container{
 						{
-							Image: "strongmonkey1992/helm-install",
+							Name:  "helm",
+							Image: helmImage,
 							VolumeMounts: []v1.VolumeMount{
 								{
-									Name: volumeName,
-									MountPath: mountPath,
+									Name:      valuesVolume,
+									MountPath: valuesDir,
 								},
 							},
-							Args: []string{
-								"helm",
-								"install",
-								""
-							}
+							Args: args,
 						},
 					},
 				},
 			},
 		},
 	}
+	addPostRenderer(obj, &job.Spec.Template.Spec)
+	return job
+}
+
+func jobName(obj *v1alpha1.Chart) string {
+	return fmt.Sprintf("helm-install-%s", obj.Name)
 }
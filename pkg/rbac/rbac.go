@@ -0,0 +1,114 @@
+package rbac
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The objects below give the service account that installs a chart, or runs a hook, its permissions.
+// They are shared by the controller and the CLI so both use the same names.
+
+func ServiceAccountName(name string) string {
+	return fmt.Sprintf("%s-sa-install", name)
+}
+
+func RoleName(name string) string {
+	return fmt.Sprintf("%s-role-install", name)
+}
+
+// ClusterRoleName includes the namespace, so charts and hooks of the same name in different namespaces don't share
+// cluster-scoped objects
+func ClusterRoleName(name, namespace string) string {
+	return fmt.Sprintf("%s-%s-clusterrole-install", namespace, name)
+}
+
+func RoleBindingName(name string) string {
+	return fmt.Sprintf("%s-rolebinding-install", name)
+}
+
+func ClusterRoleBindingName(name, namespace string) string {
+	return fmt.Sprintf("%s-%s-clusterrolebinding-install", namespace, name)
+}
+
+func ServiceAccount(name, namespace string) *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ServiceAccountName(name),
+			Namespace: namespace,
+		},
+	}
+}
+
+func Role(name, namespace string, role rbacv1.Role) *rbacv1.Role {
+	role.TypeMeta = metav1.TypeMeta{
+		APIVersion: rbacv1.SchemeGroupVersion.String(),
+		Kind:       "Role",
+	}
+	role.Name = RoleName(name)
+	role.Namespace = namespace
+	return &role
+}
+
+func ClusterRole(name, namespace string, clusterRole rbacv1.ClusterRole) *rbacv1.ClusterRole {
+	clusterRole.TypeMeta = metav1.TypeMeta{
+		APIVersion: rbacv1.SchemeGroupVersion.String(),
+		Kind:       "ClusterRole",
+	}
+	clusterRole.Name = ClusterRoleName(name, namespace)
+	return &clusterRole
+}
+
+func RoleBinding(name, namespace string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RoleBindingName(name),
+			Namespace: namespace,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     RoleName(name),
+		},
+		Subjects: subjects(name, namespace),
+	}
+}
+
+func ClusterRoleBinding(name, namespace string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ClusterRoleBindingName(name, namespace),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     ClusterRoleName(name, namespace),
+		},
+		Subjects: subjects(name, namespace),
+	}
+}
+
+func subjects(name, namespace string) []rbacv1.Subject {
+	return []rbacv1.Subject{
+		{
+			Kind:      rbacv1.ServiceAccountKind,
+			APIGroup:  v1.GroupName,
+			Name:      ServiceAccountName(name),
+			Namespace: namespace,
+		},
+	}
+}
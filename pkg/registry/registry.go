@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"bytes"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultRegistry = "docker.io"
+)
+
+// Config describes how container images in rendered manifests are rewritten to a private registry.
+type Config struct {
+	// Registry replaces the registry of every image that has no explicit mirror
+	Registry string `json:"registry,omitempty"`
+	// Mirrors maps a source registry (e.g. docker.io, quay.io) to the registry that serves its images
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+	// ImagePullSecrets are added to every pod spec that doesn't already reference them
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+}
+
+func (c Config) Enabled() bool {
+	return c.Registry != "" || len(c.Mirrors) > 0 || len(c.ImagePullSecrets) > 0
+}
+
+type Image struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImage splits an image reference into its registry, repository, tag and digest, normalizing docker hub references
+func ParseImage(image string) Image {
+	result := Image{}
+
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		result.Digest = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i+1:], "/") {
+		result.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		result.Registry = parts[0]
+		result.Repository = parts[1]
+	} else {
+		result.Registry = DefaultRegistry
+		result.Repository = name
+	}
+
+	if result.Registry == "index.docker.io" {
+		result.Registry = DefaultRegistry
+	}
+	if result.Registry == DefaultRegistry && !strings.Contains(result.Repository, "/") {
+		result.Repository = "library/" + result.Repository
+	}
+	if result.Tag == "" && result.Digest == "" {
+		result.Tag = "latest"
+	}
+
+	return result
+}
+
+func (i Image) String() string {
+	s := i.Registry + "/" + i.Repository
+	if i.Tag != "" {
+		s += ":" + i.Tag
+	}
+	if i.Digest != "" {
+		s += "@" + i.Digest
+	}
+	return s
+}
+
+// RewriteImage returns the image reference that should be pulled for image under this config
+func (c Config) RewriteImage(image string) string {
+	if image == "" {
+		return image
+	}
+
+	ref := ParseImage(image)
+	target := c.Registry
+	if mirror, ok := c.Mirrors[ref.Registry]; ok {
+		target = mirror
+	}
+	if target == "" {
+		return image
+	}
+
+	ref.Registry = strings.TrimSuffix(target, "/")
+	return ref.String()
+}
+
+// RewriteManifests rewrites the images of every workload found in a multi-document yaml stream
+func RewriteManifests(manifests []byte, config Config) ([]byte, error) {
+	var result [][]byte
+	for _, doc := range SplitDocuments(manifests) {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		for _, podSpec := range PodSpecs(obj) {
+			rewritePodSpec(podSpec, config)
+		}
+
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+
+	return bytes.Join(result, []byte("---\n")), nil
+}
+
+func rewritePodSpec(podSpec map[string]interface{}, config Config) {
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := podSpec[field].([]interface{})
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if image, ok := container["image"].(string); ok {
+				container["image"] = config.RewriteImage(image)
+			}
+		}
+	}
+
+	if len(config.ImagePullSecrets) == 0 {
+		return
+	}
+
+	secrets, _ := podSpec["imagePullSecrets"].([]interface{})
+	existing := map[string]bool{}
+	for _, s := range secrets {
+		if secret, ok := s.(map[string]interface{}); ok {
+			if name, ok := secret["name"].(string); ok {
+				existing[name] = true
+			}
+		}
+	}
+	for _, name := range config.ImagePullSecrets {
+		if !existing[name] {
+			secrets = append(secrets, map[string]interface{}{"name": name})
+			existing[name] = true
+		}
+	}
+	podSpec["imagePullSecrets"] = secrets
+}
+
+// PodSpecs returns the pod specs embedded in a workload object, including the items of a List
+func PodSpecs(obj map[string]interface{}) []map[string]interface{} {
+	kind, _ := obj["kind"].(string)
+
+	var path []string
+	switch kind {
+	case "List":
+		var result []map[string]interface{}
+		items, _ := obj["items"].([]interface{})
+		for _, item := range items {
+			if o, ok := item.(map[string]interface{}); ok {
+				result = append(result, PodSpecs(o)...)
+			}
+		}
+		return result
+	case "Pod":
+		path = []string{"spec"}
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		path = []string{"spec", "template", "spec"}
+	case "CronJob":
+		path = []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	default:
+		return nil
+	}
+
+	current := obj
+	for _, field := range path {
+		next, ok := current[field].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+	return []map[string]interface{}{current}
+}
+
+// SplitDocuments splits a multi-document yaml stream on its `---` separators
+func SplitDocuments(data []byte) [][]byte {
+	var (
+		result  [][]byte
+		current []string
+	)
+
+	flush := func() {
+		doc := strings.TrimSpace(strings.Join(current, "\n"))
+		if doc != "" {
+			result = append(result, []byte(doc+"\n"))
+		}
+		current = nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "---" || strings.HasPrefix(line, "--- ") {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return result
+}
+
+// WorkloadImage is a container image referenced by a workload
+type WorkloadImage struct {
+	Image     string `json:"image"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Init      bool   `json:"init,omitempty"`
+}
+
+// Images returns every container and init container image of the workloads found in a multi-document yaml stream
+func Images(manifests []byte) ([]WorkloadImage, error) {
+	var result []WorkloadImage
+	for _, doc := range SplitDocuments(manifests) {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		result = append(result, objectImages(obj)...)
+	}
+	return result, nil
+}
+
+func objectImages(obj map[string]interface{}) []WorkloadImage {
+	var result []WorkloadImage
+
+	if items, ok := obj["items"].([]interface{}); ok {
+		for _, item := range items {
+			if o, ok := item.(map[string]interface{}); ok {
+				result = append(result, objectImages(o)...)
+			}
+		}
+		return result
+	}
+
+	kind, _ := obj["kind"].(string)
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+
+	for _, podSpec := range PodSpecs(obj) {
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, _ := podSpec[field].([]interface{})
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				image, _ := container["image"].(string)
+				if image == "" {
+					continue
+				}
+				containerName, _ := container["name"].(string)
+				result = append(result, WorkloadImage{
+					Image:     image,
+					Kind:      kind,
+					Namespace: namespace,
+					Name:      name,
+					Container: containerName,
+					Init:      field == "initContainers",
+				})
+			}
+		}
+	}
+	return result
+}
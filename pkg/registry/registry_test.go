@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected Image
+	}{
+		{"nginx", Image{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{"nginx:1.17", Image{Registry: "docker.io", Repository: "library/nginx", Tag: "1.17"}},
+		{"rancher/k3p:v0.1", Image{Registry: "docker.io", Repository: "rancher/k3p", Tag: "v0.1"}},
+		{"index.docker.io/nginx", Image{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{"quay.io/jetstack/cert-manager:v0.14.0", Image{Registry: "quay.io", Repository: "jetstack/cert-manager", Tag: "v0.14.0"}},
+		{"localhost/app", Image{Registry: "localhost", Repository: "app", Tag: "latest"}},
+		{"registry.local:5000/app:1", Image{Registry: "registry.local:5000", Repository: "app", Tag: "1"}},
+		{"registry.local:5000/team/app", Image{Registry: "registry.local:5000", Repository: "team/app", Tag: "latest"}},
+		{"nginx@sha256:abc", Image{Registry: "docker.io", Repository: "library/nginx", Digest: "sha256:abc"}},
+		{"gcr.io/app:1@sha256:abc", Image{Registry: "gcr.io", Repository: "app", Tag: "1", Digest: "sha256:abc"}},
+	}
+	for _, test := range tests {
+		if actual := ParseImage(test.image); actual != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.image, test.expected, actual)
+		}
+	}
+}
+
+func TestRewriteImage(t *testing.T) {
+	config := Config{
+		Registry: "registry.local/",
+		Mirrors:  map[string]string{"quay.io": "quay-mirror.local"},
+	}
+	tests := map[string]string{
+		"":                           "",
+		"nginx":                      "registry.local/library/nginx:latest",
+		"quay.io/jetstack/cm:v1":     "quay-mirror.local/jetstack/cm:v1",
+		"gcr.io/app@sha256:abc":      "registry.local/app@sha256:abc",
+		"registry.local:5000/app:v1": "registry.local/app:v1",
+	}
+	for image, expected := range tests {
+		if actual := config.RewriteImage(image); actual != expected {
+			t.Errorf("%s: expected %s, got %s", image, expected, actual)
+		}
+	}
+
+	mirrorsOnly := Config{Mirrors: map[string]string{"quay.io": "quay-mirror.local"}}
+	if actual := mirrorsOnly.RewriteImage("nginx:1"); actual != "nginx:1" {
+		t.Errorf("expected an image without a mirror to be kept without a registry, got %s", actual)
+	}
+}
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected []string
+	}{
+		{"", nil},
+		{"a: 1\n", []string{"a: 1\n"}},
+		{"---\na: 1\n---\n\n---\nb: 2\n", []string{"a: 1\n", "b: 2\n"}},
+		{"a: 1\n--- # comment\nb: |\n  ---x\n", []string{"a: 1\n", "b: |\n  ---x\n"}},
+	}
+	for _, test := range tests {
+		var actual []string
+		for _, doc := range SplitDocuments([]byte(test.data)) {
+			actual = append(actual, string(doc))
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.data, test.expected, actual)
+		}
+	}
+}
+
+func TestRewriteManifests(t *testing.T) {
+	manifests := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      imagePullSecrets:
+      - name: existing
+      initContainers:
+      - name: init
+        image: busybox
+      containers:
+      - name: web
+        image: quay.io/team/web:v1
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: backup
+            image: backup:1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+`
+	config := Config{
+		Registry:         "registry.local",
+		Mirrors:          map[string]string{"quay.io": "quay-mirror.local"},
+		ImagePullSecrets: []string{"existing", "registry"},
+	}
+	result, err := RewriteManifests([]byte(manifests), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := Images(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, image := range images {
+		actual = append(actual, image.Image)
+	}
+	expected := []string{
+		"registry.local/library/busybox:latest",
+		"quay-mirror.local/team/web:v1",
+		"registry.local/library/backup:1",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected images %v, got %v", expected, actual)
+	}
+
+	docs := SplitDocuments(result)
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 documents, got %d", len(docs))
+	}
+	if strings.Count(string(docs[0]), "name: existing") != 1 || !strings.Contains(string(docs[0]), "name: registry") {
+		t.Errorf("expected the registry secret to be added once to the deployment:\n%s", docs[0])
+	}
+	if !strings.Contains(string(docs[1]), "name: registry") {
+		t.Errorf("expected the pull secrets to be added to the cron job:\n%s", docs[1])
+	}
+	if strings.Contains(string(docs[2]), "imagePullSecrets") {
+		t.Errorf("expected the service to be unchanged:\n%s", docs[2])
+	}
+}